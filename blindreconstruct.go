@@ -3,8 +3,6 @@ package chunk
 import (
 	"bufio"
 	"context"
-	"crypto/sha256"
-	"errors"
 	"io"
 	"sort"
 	"sync"
@@ -26,7 +24,7 @@ type BlindReconstructor struct {
 
 // Sum224 checks whether the streaming of chunks to the output stream is finished.
 // If it is ongoing, an error is returned.
-// Otherwise, the SHA-224 checksum of the stream is returned with no error.
+// Otherwise, the checksum of the stream is returned with no error.
 func (br *BlindReconstructor) Sum224() (Sum224, error) {
 	return br.sum224()
 }
@@ -34,39 +32,71 @@ func (br *BlindReconstructor) Sum224() (Sum224, error) {
 // Err returns any error encountered when writing to the output stream
 // if finished==true.
 // If finished==false, err is undefined.
+// Errors caused by a particular chunk are returned as a *ChunkError.
 func (br *BlindReconstructor) Err() (finished bool, err error) {
 	return br.finErr()
 }
 
 // Submit sinks chunk c (in any order) for the purpose of reconstructing the
 // original file.
-// All chunks will be reordered by br.
+// All chunks will be reordered by br. Compressed chunks are decompressed
+// and sealed chunks are opened, see SetKeyRing.
 // Submitting the same idx more than once will yield an error but does not
 // change br's state.
 // Submitting the same chunk under a different idx is OK.
+// Errors concerning c are returned as a *ChunkError. If br has already
+// stopped, the error it stopped with (see Err) is returned.
 func (br *BlindReconstructor) Submit(c *C, idx int) error {
+	if c.alg != br.alg {
+		return &ChunkError{Index: idx, Sum: c.Sum224(), Err: ErrHashMismatch}
+	}
+	d, err := br.plain(c, DefaultMaxChunkSize)
+	if err != nil {
+		return &ChunkError{Index: idx, Sum: c.Sum224(), Err: err}
+	}
+	c = d
+
 	br.mu.Lock()
 
 	if _, ok := br.submittedIndexes[idx]; ok {
 		br.mu.Unlock()
-		return errResubmitSameIndex
+		return &ChunkError{Index: idx, Sum: c.Sum224(), Err: ErrResubmitSameIndex}
 	}
 
 	if br.fin {
 		br.mu.Unlock()
-		return errFinishedReconstructor
+		return &ChunkError{Index: idx, Sum: c.Sum224(), Err: ErrFinishedReconstructor}
 	}
 
 	br.submittedIndexes[idx] = struct{}{}
 
-	br.sorter = append(br.sorter, &indexedC{C{c.b, c.h224}, idx})
+	br.buffer(&indexedC{*c, idx, &held{c.b, "", 0}})
 	sort.Sort(br.sorter)
+	br.spillOver()
 
 	br.mu.Unlock()
 
-	br.lastReceivedIndex <- idx
+	return br.notify(idx)
+}
 
-	return nil
+// SetKeyRing makes br open sealed chunks with kr. It must be called before
+// submitting sealed chunks, which are rejected otherwise.
+func (br *BlindReconstructor) SetKeyRing(kr *KeyRing) {
+	br.mu.Lock()
+	br.keys = kr
+	br.mu.Unlock()
+}
+
+// SetSpill caps the memory taken by chunks submitted ahead of their turn, see
+// Reconstructor.SetSpill.
+func (br *BlindReconstructor) SetSpill(dir string, maxMem int64) error {
+	return br.setSpill(dir, maxMem)
+}
+
+// BufferStats reports on the chunks br holds until their turn to be written
+// comes.
+func (br *BlindReconstructor) BufferStats() BufferStats {
+	return br.bufferStats()
 }
 
 // Close closes and cleans up after br. Close signals the underlying writer to
@@ -74,16 +104,16 @@ func (br *BlindReconstructor) Submit(c *C, idx int) error {
 func (br *BlindReconstructor) Close() (outErr error) {
 	defer func() {
 		if r := recover(); r != nil {
-			outErr = errors.New("attemping to close a closed br")
+			outErr = ErrClosedReconstructor
 		}
 	}()
 	br.closed <- struct{}{}
 	br.mu.Lock()
 	defer br.mu.Unlock()
 	if len(br.sorter) > 0 {
-		br.err = errUnprocessedChunksQueued
+		br.err = ErrUnprocessedChunksQueued
 	}
-	br.sorter = nil // deref all unprocessed chunks for gc
+	br.dropBuffered()
 	br.fin = true
 	return br.err
 }
@@ -100,29 +130,61 @@ func (br *BlindReconstructor) NumSubmit() int {
 // Every chunk sunk (in any order) into the returned Reconstructor will be
 // written to w in order, but the caller must specify the chunk's index.
 func BlindReconstruct(wc io.WriteCloser, timeout time.Duration) *BlindReconstructor {
+	return BlindReconstructHash(wc, SHA224, timeout)
+}
+
+// BlindReconstructHash is like BlindReconstruct but only accepts chunks
+// created with alg, which is also used for the checksum of the whole stream.
+// The returned BlindReconstructor is nil if alg is unknown.
+func BlindReconstructHash(wc io.WriteCloser, alg Hash, timeout time.Duration) *BlindReconstructor {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	return blindReconstruct(ctx, cancel, wc, alg)
+}
+
+// BlindReconstructContext is like BlindReconstruct but runs until ctx is done
+// or the BlindReconstructor is closed, instead of until a timeout elapses.
+func BlindReconstructContext(ctx context.Context, wc io.WriteCloser) *BlindReconstructor {
+	ctx, cancel := context.WithCancel(ctx)
+	return blindReconstruct(ctx, cancel, wc, SHA224)
+}
+
+// blindReconstruct runs until closed or ctx is done, then calls cancel.
+func blindReconstruct(ctx context.Context, cancel context.CancelFunc,
+	wc io.WriteCloser, alg Hash) *BlindReconstructor {
+
+	if !alg.Available() {
+		cancel()
+		return nil
+	}
 
 	br := &BlindReconstructor{
 		make(chan struct{}),
 		make(map[int]struct{}),
 		reconstructor{
 			make(chan int),
+			make(chan struct{}),
 			sync.Mutex{},
-			sha256.New224(),
+			alg,
+			alg.New(),
 			[]*indexedC{},
 			false,
 			nil,
+			nil,
+			spiller{},
 		},
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), timeout)
-
 	go func() {
 		bw := bufio.NewWriterSize(wc, writeBufferSize)
 		defer func() {
 			bw.Flush()
 			wc.Close()
 			cancel()
+			br.mu.Lock()
+			br.removeSpill()
+			br.mu.Unlock()
 			close(br.closed)
+			close(br.done)
 		}()
 
 		nextIndex := 0
@@ -143,7 +205,7 @@ func BlindReconstruct(wc io.WriteCloser, timeout time.Duration) *BlindReconstruc
 
 					br.mu.Lock()
 					for len(br.sorter) > 0 && nextIndex == br.sorter[len(br.sorter)-1].idx {
-						err := br.writeChunk(bw)
+						_, err := br.writeChunk(bw, nil)
 						if err != nil {
 							br.err = err
 							br.fin = true
@@ -2,6 +2,7 @@ package chunk
 
 import (
 	"bytes"
+	"errors"
 	"sync"
 	"testing"
 	"time"
@@ -17,10 +18,12 @@ func TestBlindRecEarlyClose(t *testing.T) {
 	assert.Nil(t, err)
 	err = br.Submit(cFromFile(t, "testdata/chunk2"), 1)
 	assert.NotNil(t, err)
+	assert.True(t, errors.Is(err, ErrResubmitSameIndex))
+	assert.Equal(t, 1, err.(*ChunkError).Index)
 
 	err = br.Close()
 	assert.NotNil(t, err)
-	assert.Equal(t, errUnprocessedChunksQueued, err)
+	assert.Equal(t, ErrUnprocessedChunksQueued, err)
 
 	top224, err := br.Sum224()
 	assert.Nil(t, err)
@@ -0,0 +1,129 @@
+package chunk
+
+import (
+	"bufio"
+	"io"
+	"math/bits"
+	"time"
+)
+
+// gear is the table of random 64-bit values used by the rolling gear hash.
+// It is derived from a fixed seed so that the cut points, and therefore the
+// chunk checksums, are stable across processes and releases.
+var gear [256]uint64
+
+func init() {
+	x := uint64(0x6368756e6b676561) // "chunkgea"
+	for i := range gear {
+		// splitmix64
+		x += 0x9e3779b97f4a7c15
+		z := x
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		gear[i] = z ^ (z >> 31)
+	}
+}
+
+// cdc cuts an input stream at content-defined boundaries using the FastCDC
+// normalized chunking scheme on top of a gear rolling hash.
+type cdc struct {
+	min, avg, max int64
+	maskS, maskL  uint64 // harder/easier cut conditions before/after avg
+}
+
+func newCDC(min, avg, max int64) *cdc {
+	n := uint(bits.Len64(uint64(avg)) - 1)
+	l := n
+	if l > 0 {
+		l--
+	}
+	return &cdc{
+		min,
+		avg,
+		max,
+		highBits(n + 1),
+		highBits(l),
+	}
+}
+
+// highBits returns a mask with the n most significant bits set.
+// The gear hash is shifted left on every byte, so its high bits depend on the
+// widest window of input.
+func highBits(n uint) uint64 {
+	if n == 0 {
+		return 0
+	}
+	return ^uint64(0) << (64 - n)
+}
+
+// cut returns the length of the first chunk in src.
+func (c *cdc) cut(src []byte) int64 {
+	n := int64(len(src))
+	if n <= c.min {
+		return n
+	}
+	if n > c.max {
+		n = c.max
+	}
+	normal := c.avg
+	if normal > n {
+		normal = n
+	}
+
+	var fp uint64
+	i := c.min
+	for ; i < normal; i++ {
+		fp = (fp << 1) + gear[src[i]]
+		if fp&c.maskS == 0 {
+			return i + 1
+		}
+	}
+	for ; i < n; i++ {
+		fp = (fp << 1) + gear[src[i]]
+		if fp&c.maskL == 0 {
+			return i + 1
+		}
+	}
+	return n
+}
+
+// next writes the next content-defined chunk from br into dst.
+// br must be able to buffer at least c.max bytes.
+func (c *cdc) next(br *bufio.Reader, dst io.Writer) (int64, error) {
+	buf, err := br.Peek(int(c.max))
+	if err != nil && err != io.EOF {
+		return 0, err
+	}
+	if len(buf) == 0 {
+		return 0, io.EOF
+	}
+
+	n, err := dst.Write(buf[:c.cut(buf)])
+	if err != nil {
+		return int64(n), err
+	}
+	_, err = br.Discard(n)
+	return int64(n), err
+}
+
+// SplitStreamCDC cuts up an input stream rc into chunks whose boundaries are
+// determined by the content of rc rather than by fixed offsets, so that an
+// insertion or deletion only affects the chunks around it.
+// Every chunk is at least min and at most max bytes long, except for the last
+// one which may be shorter than min. Chunk lengths are normalized around avg.
+// The returned Sequence behaves exactly like the one returned by SplitStream,
+// and its Metadata has a Width of 0.
+// The returned Sequence is nil if min<1, avg<min, max<avg, bufSize<0,
+// rc==nil, or timeout<1ms.
+func SplitStreamCDC(rc io.ReadCloser, min, avg, max int64, bufSize int,
+	timeout time.Duration) *Sequence {
+
+	sp := &Splitter{
+		MinWidth: min,
+		AvgWidth: avg,
+		MaxWidth: max,
+		BufSize:  bufSize,
+		Timeout:  timeout,
+	}
+	return sp.Split(rc)
+}
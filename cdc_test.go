@@ -0,0 +1,102 @@
+package chunk
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSplitStreamCDC(t *testing.T) {
+	data := make([]byte, 256*1024)
+	rand.New(rand.NewSource(1)).Read(data)
+
+	s := SplitStreamCDC(ioutil.NopCloser(bytes.NewReader(data)), 2048, 8192, 32768, 4, 1*time.Second)
+	assert.NotNil(t, s)
+
+	var chunks []*C
+	for c := s.Next(); c != nil; c = s.Next() {
+		chunks = append(chunks, c)
+	}
+	fin, err := s.Err()
+	assert.True(t, fin)
+	assert.Nil(t, err)
+
+	joined := bytes.NewBuffer(nil)
+	for i, c := range chunks {
+		n := c.Reader().Len()
+		assert.True(t, n <= 32768)
+		if i < len(chunks)-1 {
+			assert.True(t, n >= 2048)
+		}
+		joined.ReadFrom(c.Reader())
+	}
+	assert.Equal(t, data, joined.Bytes())
+	assert.True(t, len(chunks) > 1)
+
+	m, err := s.Metadata()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), m.Width)
+	assert.Equal(t, len(chunks), len(m.ChunkChecksums))
+	assert.Equal(t, int64(len(data)), m.Size)
+	for i, c := range chunks {
+		assert.Equal(t, int64(c.Reader().Len()), m.ChunkLengths[i])
+	}
+
+	// the existing Reconstructor consumes the metadata as is
+	out := noopCloseWriteCloser{bytes.NewBuffer(nil), &sync.Mutex{}}
+	rec := Reconstruct(out, m.ChunkChecksums, 1*time.Second)
+	for i := len(chunks) - 2; i >= 0; i-- {
+		assert.Nil(t, rec.Submit(chunks[i]))
+	}
+	assert.Nil(t, rec.Submit(chunks[len(chunks)-1]))
+	time.Sleep(200 * time.Millisecond)
+	top224, err := rec.Sum224()
+	assert.Nil(t, err)
+	assert.Equal(t, m.TopChecksum, top224)
+	assert.Equal(t, string(data), out.String())
+}
+
+func TestCDCShiftResistance(t *testing.T) {
+	data := make([]byte, 256*1024)
+	rand.New(rand.NewSource(2)).Read(data)
+	shifted := append([]byte{0x42}, data...)
+
+	sums := func(b []byte) map[Sum224]struct{} {
+		s := SplitStreamCDC(ioutil.NopCloser(bytes.NewReader(b)), 1024, 4096, 16384, 0, 1*time.Second)
+		res := make(map[Sum224]struct{})
+		for c := s.Next(); c != nil; c = s.Next() {
+			res[c.Sum224()] = struct{}{}
+		}
+		return res
+	}
+
+	before, after := sums(data), sums(shifted)
+	shared := 0
+	for k := range after {
+		if _, ok := before[k]; ok {
+			shared++
+		}
+	}
+	// only the chunk(s) around the insertion may differ
+	assert.True(t, shared >= len(before)-2)
+}
+
+func TestSplitStreamCDCInvalidArgs(t *testing.T) {
+	r := ioutil.NopCloser(bytes.NewReader(nil))
+	assert.Nil(t, SplitStreamCDC(r, 0, 8, 16, 0, 1*time.Second))
+	assert.Nil(t, SplitStreamCDC(r, 8, 4, 16, 0, 1*time.Second))
+	assert.Nil(t, SplitStreamCDC(r, 4, 8, 6, 0, 1*time.Second))
+	assert.Nil(t, SplitStreamCDC(nil, 4, 8, 16, 0, 1*time.Second))
+
+	// empty input yields no chunks
+	s := SplitStreamCDC(r, 4, 8, 16, 0, 1*time.Second)
+	assert.Nil(t, s.Next())
+	m, err := s.Metadata()
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(m.ChunkChecksums))
+}
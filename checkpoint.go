@@ -0,0 +1,385 @@
+package chunk
+
+import (
+	"bytes"
+	"context"
+	"encoding"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// checkpointMagic prefixes every binary encoded Checkpoint.
+var checkpointMagic = [4]byte{'C', 'H', 'N', 'P'}
+
+// checkpointVersion is the version of the binary checkpoint format.
+const checkpointVersion = 1
+
+// Checkpoint records the progress of a Reconstructor, so that an interrupted
+// reconstruction can be resumed against the same output, see
+// Reconstructor.SetCheckpoint.
+type Checkpoint struct {
+	Hash        Hash
+	TopChecksum Sum224 // of the Metadata being reconstructed
+	Written     []bool // whether every chunk has been written, by index
+
+	// Offset is the length of the output written without gap from its
+	// start, i.e. the offset of the first chunk not written.
+	Offset int64
+
+	// HashState is the state of the checksum of the output up to Offset,
+	// as marshaled by the hash.Hash of Hash. It is only recorded for
+	// Reconstructors writing sequentially, not by those of ReconstructAt.
+	HashState []byte
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler.
+//
+// The layout, with all integers big-endian, is:
+//
+//	magic "CHNP" | version uint8 | hash uint8 | top checksum |
+//	chunk count uint32 | offset int64 | bitmap of the chunks written |
+//	hash state length uint32 | hash state
+//
+// The bitmap holds a bit per chunk, chunk 0 being the lowest bit of the first
+// byte.
+func (cp *Checkpoint) MarshalBinary() ([]byte, error) {
+	if !cp.Hash.Available() {
+		return nil, ErrUnknownHash
+	}
+	buf := bytes.NewBuffer(nil)
+	buf.Write(checkpointMagic[:])
+	buf.WriteByte(checkpointVersion)
+	buf.WriteByte(byte(cp.Hash))
+	buf.Write(cp.TopChecksum[:])
+	binary.Write(buf, binary.BigEndian, uint32(len(cp.Written)))
+	binary.Write(buf, binary.BigEndian, cp.Offset)
+	bitmap := make([]byte, (len(cp.Written)+7)/8)
+	for i, v := range cp.Written {
+		if v {
+			bitmap[i/8] |= 1 << uint(i%8)
+		}
+	}
+	buf.Write(bitmap)
+	binary.Write(buf, binary.BigEndian, uint32(len(cp.HashState)))
+	buf.Write(cp.HashState)
+	return buf.Bytes(), nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler.
+// b must hold exactly one checkpoint as produced by MarshalBinary, otherwise
+// an error is returned and cp is left untouched.
+func (cp *Checkpoint) UnmarshalBinary(b []byte) error {
+	r := bytes.NewReader(b)
+
+	var magic [4]byte
+	if _, err := io.ReadFull(r, magic[:]); err != nil {
+		return ErrCheckpointTruncated
+	}
+	if magic != checkpointMagic {
+		return ErrCheckpointMagic
+	}
+	version, err := r.ReadByte()
+	if err != nil {
+		return ErrCheckpointTruncated
+	}
+	if version != checkpointVersion {
+		return ErrCheckpointVersion
+	}
+
+	res := Checkpoint{}
+	alg, err := r.ReadByte()
+	if err != nil {
+		return ErrCheckpointTruncated
+	}
+	res.Hash = Hash(alg)
+	if !res.Hash.Available() {
+		return ErrUnknownHash
+	}
+	if _, err := io.ReadFull(r, res.TopChecksum[:]); err != nil {
+		return ErrCheckpointTruncated
+	}
+	var count uint32
+	if err := binary.Read(r, binary.BigEndian, &count); err != nil {
+		return ErrCheckpointTruncated
+	}
+	if err := binary.Read(r, binary.BigEndian, &res.Offset); err != nil {
+		return ErrCheckpointTruncated
+	}
+	if (int64(count)+7)/8 > int64(r.Len()) {
+		return ErrCheckpointTruncated
+	}
+	bitmap := make([]byte, (count+7)/8)
+	io.ReadFull(r, bitmap)
+	res.Written = make([]bool, count)
+	for i := range res.Written {
+		res.Written[i] = bitmap[i/8]&(1<<uint(i%8)) != 0
+	}
+	var n uint32
+	if err := binary.Read(r, binary.BigEndian, &n); err != nil {
+		return ErrCheckpointTruncated
+	}
+	if int64(n) > int64(r.Len()) {
+		return ErrCheckpointTruncated
+	}
+	if n > 0 {
+		res.HashState = make([]byte, n)
+		io.ReadFull(r, res.HashState)
+	}
+	if r.Len() > 0 {
+		return ErrCheckpointTrailingData
+	}
+
+	*cp = res
+	return nil
+}
+
+// LoadCheckpoint reads the checkpoint saved at path.
+func LoadCheckpoint(path string) (*Checkpoint, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cp := &Checkpoint{}
+	if err := cp.UnmarshalBinary(b); err != nil {
+		return nil, err
+	}
+	return cp, nil
+}
+
+// next returns the number of chunks written sequentially from the start.
+func (cp *Checkpoint) next() int {
+	for i, v := range cp.Written {
+		if !v {
+			return i
+		}
+	}
+	return len(cp.Written)
+}
+
+// check returns ErrCheckpointMismatch unless cp records the progress of a
+// reconstruction of m. If sequential is true, cp must have been recorded by
+// a Reconstructor writing sequentially.
+func (cp *Checkpoint) check(m *Metadata, sequential bool) error {
+	if cp.Hash != m.Hash || !cp.TopChecksum.Eq(m.TopChecksum) ||
+		len(cp.Written) != len(m.ChunkChecksums) {
+		return ErrCheckpointMismatch
+	}
+	next := cp.next()
+	off := m.Size
+	if next < len(m.ChunkOffsets) {
+		off = m.ChunkOffsets[next]
+	}
+	if cp.Offset != off {
+		return ErrCheckpointMismatch
+	}
+	if !sequential {
+		return nil
+	}
+	for _, v := range cp.Written[next:] {
+		if v {
+			return ErrCheckpointMismatch
+		}
+	}
+	if cp.HashState == nil {
+		return ErrCheckpointMismatch
+	}
+	return nil
+}
+
+// OpenOutput opens the file named name, the output of the interrupted
+// reconstruction, for a sequential reconstruction to resume writing to it.
+// The file is truncated to cp.Offset, dropping anything written past the
+// checkpoint, and positioned at its end.
+func (cp *Checkpoint) OpenOutput(name string) (*os.File, error) {
+	f, err := os.OpenFile(name, os.O_WRONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+	if err = f.Truncate(cp.Offset); err == nil {
+		_, err = f.Seek(cp.Offset, io.SeekStart)
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// checkpointer saves the progress of a Reconstructor to a file.
+type checkpointer struct {
+	path  string
+	every time.Duration // between saves, only when stopping if 0
+	last  time.Time     // of the last save
+}
+
+// due reports whether a periodic save is due.
+func (ck *checkpointer) due() bool {
+	return ck.every > 0 && time.Since(ck.last) >= ck.every
+}
+
+// save writes cp to ck.path, atomically.
+func (ck *checkpointer) save(cp *Checkpoint) error {
+	b, err := cp.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(filepath.Dir(ck.path), ".tmp-")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+
+	_, err = f.Write(b)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, ck.path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+	}
+	ck.last = time.Now()
+	return err
+}
+
+// syncOutput commits the output to stable storage if it can, before a
+// checkpoint vouches for it.
+func syncOutput(w interface{}) error {
+	if s, ok := w.(interface{ Sync() error }); ok {
+		return s.Sync()
+	}
+	return nil
+}
+
+// SetCheckpoint makes rec save its progress to a checkpoint file at path,
+// every interval and when it stops before completing, so that the
+// reconstruction can be resumed with ResumeReconstruct or
+// ResumeReconstructAt. The file is removed once the reconstruction completes.
+// An interval of 0 only saves when stopping. The output is synced, if it has
+// a Sync method like *os.File, before every save.
+// A failed periodic save stops the reconstruction.
+// It fails if rec has stopped, was not created from a Metadata, or writes
+// sequentially with a hash algorithm whose state cannot be saved (XXH3).
+func (rec *Reconstructor) SetCheckpoint(path string, interval time.Duration) error {
+	if path == "" || interval < 0 || rec.top == nil {
+		return ErrInvalidArgs
+	}
+	if rec.at == nil {
+		if _, ok := rec.h224.(encoding.BinaryMarshaler); !ok {
+			return ErrHashState
+		}
+	}
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	if rec.fin {
+		return ErrFinishedReconstructor
+	}
+	rec.ckpt = &checkpointer{path, interval, time.Now()}
+	return nil
+}
+
+// assume external lock
+// checkpoint returns the progress of rec, next being the number of chunks
+// written sequentially and written the length of their data. Both are
+// ignored in ReconstructAt mode.
+func (rec *Reconstructor) checkpoint(next int, written int64) (*Checkpoint, error) {
+	cp := &Checkpoint{
+		rec.alg,
+		*rec.top,
+		make([]bool, len(rec.chunkHashes)),
+		written,
+		nil,
+	}
+	if rec.at != nil {
+		for i := range cp.Written {
+			cp.Written[i] = rec.at.isSet(i)
+		}
+		cp.Offset = rec.at.size
+		if i := cp.next(); i < len(rec.at.offsets) {
+			cp.Offset = rec.at.offsets[i]
+		}
+		return cp, nil
+	}
+
+	for i := 0; i < next; i++ {
+		cp.Written[i] = true
+	}
+	state, err := rec.h224.(encoding.BinaryMarshaler).MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	cp.HashState = state
+	return cp, nil
+}
+
+// assume external lock
+// saveCheckpoint syncs w, the output, and saves the progress of rec. See
+// checkpoint for next and written.
+func (rec *Reconstructor) saveCheckpoint(w interface{}, next int, written int64) error {
+	if err := syncOutput(w); err != nil {
+		return err
+	}
+	cp, err := rec.checkpoint(next, written)
+	if err != nil {
+		return err
+	}
+	return rec.ckpt.save(cp)
+}
+
+// assume external lock
+// closeCheckpoint saves the progress of rec if it stopped before completing,
+// and removes the checkpoint file otherwise. See checkpoint for next and
+// written.
+func (rec *Reconstructor) closeCheckpoint(w interface{}, next int, written int64) {
+	if rec.ckpt == nil {
+		return
+	}
+	if rec.fin && rec.err == nil {
+		os.Remove(rec.ckpt.path)
+		return
+	}
+	rec.saveCheckpoint(w, next, written)
+}
+
+// ResumeReconstruct is like ReconstructMetadataContext but resumes the
+// reconstruction whose progress was saved in cp, see SetCheckpoint. wc must
+// write after the cp.Offset first bytes of the interrupted reconstruction's
+// output, see Checkpoint.OpenOutput.
+// Chunks written before the checkpoint can still be submitted, and are
+// ignored. Checkpoints are only saved again once enabled with SetCheckpoint.
+// The returned Reconstructor is nil if m has no chunks or is inconsistent, or
+// if cp does not record a sequential reconstruction of m.
+func ResumeReconstruct(ctx context.Context, wc io.WriteCloser, m *Metadata, cp *Checkpoint) *Reconstructor {
+	if m.validate() != nil || cp.check(m, true) != nil {
+		return nil
+	}
+	h := m.Hash.New()
+	u, ok := h.(encoding.BinaryUnmarshaler)
+	if !ok || u.UnmarshalBinary(cp.HashState) != nil {
+		return nil
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	return resumeReconstruct(ctx, cancel, wc, m, true, cp.next(), h)
+}
+
+// ResumeReconstructAt is like ReconstructAt but resumes the reconstruction
+// whose progress was saved in cp, see SetCheckpoint, against wa, the output of
+// the interrupted reconstruction.
+// Chunks written before the checkpoint can still be submitted, and are
+// ignored. Checkpoints are only saved again once enabled with SetCheckpoint.
+// The returned Reconstructor is nil if ReconstructAt would return nil, or if
+// cp does not record a reconstruction of m.
+func ResumeReconstructAt(ctx context.Context, wa io.WriterAt, m *Metadata, cp *Checkpoint) *Reconstructor {
+	if m.validate() != nil || cp.check(m, false) != nil {
+		return nil
+	}
+	return reconstructAt(ctx, wa, m, cp.Written)
+}
@@ -0,0 +1,165 @@
+package chunk
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckpointBinary(t *testing.T) {
+	cp := &Checkpoint{
+		SHA256,
+		Sum224{1, 2, 3},
+		[]bool{true, true, false, true, false, false, false, false, true, false},
+		200,
+		[]byte("state"),
+	}
+	b, err := cp.MarshalBinary()
+	assert.Nil(t, err)
+	var cp2 Checkpoint
+	assert.Nil(t, cp2.UnmarshalBinary(b))
+	assert.Equal(t, *cp, cp2)
+
+	assert.Equal(t, ErrCheckpointTruncated, cp2.UnmarshalBinary(b[:len(b)-1]))
+	assert.Equal(t, ErrCheckpointTrailingData, cp2.UnmarshalBinary(append(b, 0)))
+	assert.Equal(t, ErrCheckpointMagic, cp2.UnmarshalBinary([]byte("CHNK\x06")))
+	assert.Equal(t, *cp, cp2)
+}
+
+// checkpointed splits data, reconstructs part of it to a file with
+// checkpoints, then interrupts the reconstruction. It returns the metadata,
+// the chunks, the output file and the checkpoint file.
+func checkpointed(t *testing.T, dir string, alg Hash, data []byte) (*Metadata, []*C, string, string) {
+	sp := &Splitter{Hash: alg, Width: 100, Timeout: 1 * time.Second}
+	s := sp.Split(ioutil.NopCloser(bytes.NewReader(data)))
+	var chunks []*C
+	for c := s.Next(); c != nil; c = s.Next() {
+		chunks = append(chunks, c)
+	}
+	m, err := s.Metadata()
+	assert.Nil(t, err)
+
+	out := filepath.Join(dir, "out")
+	f, err := os.Create(out)
+	assert.Nil(t, err)
+	ckpt := filepath.Join(dir, "ckpt")
+	ctx, cancel := context.WithCancel(context.Background())
+	rec := ReconstructMetadataContext(ctx, f, m)
+	assert.Nil(t, rec.SetCheckpoint(ckpt, 0))
+	for _, i := range []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 15} {
+		assert.Nil(t, rec.Submit(chunks[i]))
+	}
+	time.Sleep(100 * time.Millisecond)
+	cancel()
+	<-rec.Done()
+	return m, chunks, out, ckpt
+}
+
+func TestResumeReconstruct(t *testing.T) {
+	for _, alg := range []Hash{SHA224, SHA256, BLAKE2b256} {
+		dir, err := ioutil.TempDir("", "checkpoint")
+		assert.Nil(t, err)
+		defer os.RemoveAll(dir)
+
+		data := make([]byte, 2000)
+		rand.New(rand.NewSource(9)).Read(data)
+		m, chunks, out, ckpt := checkpointed(t, dir, alg, data)
+
+		cp, err := LoadCheckpoint(ckpt)
+		assert.Nil(t, err)
+		assert.Equal(t, int64(1000), cp.Offset)
+		assert.Equal(t, 10, cp.next())
+		assert.NotNil(t, cp.HashState)
+
+		f, err := cp.OpenOutput(out)
+		assert.Nil(t, err)
+		rec := ResumeReconstruct(context.Background(), f, m, cp)
+		assert.NotNil(t, rec)
+		assert.Nil(t, rec.SetCheckpoint(ckpt, time.Nanosecond))
+		for _, c := range chunks {
+			assert.Nil(t, rec.Submit(c))
+		}
+		<-rec.Done()
+		_, err = rec.Err()
+		assert.Nil(t, err)
+		sum, err := rec.Sum224()
+		assert.Nil(t, err)
+		assert.Equal(t, m.TopChecksum, sum)
+
+		b, err := ioutil.ReadFile(out)
+		assert.Nil(t, err)
+		assert.Equal(t, data, b)
+		_, err = os.Stat(ckpt)
+		assert.True(t, os.IsNotExist(err))
+
+		// checkpoints of other reconstructions are rejected
+		other := *m
+		other.TopChecksum[0]++
+		assert.Nil(t, ResumeReconstruct(context.Background(), f, &other, cp))
+	}
+}
+
+func TestResumeReconstructAt(t *testing.T) {
+	dir, err := ioutil.TempDir("", "checkpoint")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	data := make([]byte, 2000)
+	rand.New(rand.NewSource(10)).Read(data)
+	m, chunks := metadataOf(t, data, 100)
+
+	f, err := os.Create(filepath.Join(dir, "out"))
+	assert.Nil(t, err)
+	defer f.Close()
+	ckpt := filepath.Join(dir, "ckpt")
+	ctx, cancel := context.WithCancel(context.Background())
+	rec := ReconstructAt(ctx, f, m)
+	assert.Nil(t, rec.SetCheckpoint(ckpt, 0))
+	for _, i := range []int{3, 0, 1, 7, 19} {
+		assert.Nil(t, rec.Submit(chunks[i]))
+	}
+	cancel()
+	<-rec.Done()
+
+	cp, err := LoadCheckpoint(ckpt)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(200), cp.Offset)
+	assert.Nil(t, cp.HashState)
+	// a sequential reconstruction cannot resume from it
+	assert.Nil(t, ResumeReconstruct(context.Background(), f, m, cp))
+
+	rec = ResumeReconstructAt(context.Background(), f, m, cp)
+	assert.Equal(t, 15, len(rec.Missing()))
+	assert.Nil(t, rec.SetCheckpoint(ckpt, 0))
+	for _, c := range chunks {
+		assert.Nil(t, rec.Submit(c))
+	}
+	<-rec.Done()
+	_, err = rec.Err()
+	assert.Nil(t, err)
+	b, err := ioutil.ReadFile(f.Name())
+	assert.Nil(t, err)
+	assert.Equal(t, data, b)
+	_, err = os.Stat(ckpt)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestCheckpointXXH3(t *testing.T) {
+	m, _ := metadataOf(t, []byte("aaaabbbb"), 4)
+	m.Hash = XXH3
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	rec := ReconstructMetadataContext(ctx, nopWriteCloser{ioutil.Discard}, m)
+	assert.Equal(t, ErrHashState, rec.SetCheckpoint("ckpt", 0))
+
+	// without a Metadata
+	rec = ReconstructContext(ctx, nopWriteCloser{ioutil.Discard}, m.ChunkChecksums)
+	assert.Equal(t, ErrInvalidArgs, rec.SetCheckpoint("ckpt", 0))
+}
@@ -2,7 +2,6 @@ package chunk
 
 import (
 	"bytes"
-	"crypto/sha256"
 	"hash"
 	"io"
 	"io/ioutil"
@@ -10,38 +9,146 @@ import (
 
 // C (for chunk) represents a fraction of the data resulting from slicing up
 // an input stream.
+// A chunk may hold its data compressed with a Codec and/or sealed by a
+// Sealer, in which case its checksum is still the one of the original data.
 type C struct {
-	b    []byte
-	h224 hash.Hash
+	b      []byte
+	h224   hash.Hash
+	alg    Hash
+	codec  Codec // nil if b is not compressed
+	sealed bool  // b is compressed before being sealed
 }
 
-// Reader returns a read-only view of the underlying []byte stored in c.
+// Reader returns a read-only view of the underlying []byte stored in c, which
+// is compressed if c.Codec() is not nil and sealed if c.Sealed().
 func (c *C) Reader() *bytes.Reader {
 	return bytes.NewReader(c.b)
 }
 
-// IsHash returns true if SHA224 hash h is the same as the hash recorded
+// IsHash returns true if checksum h is the same as the checksum recorded
 // during the creation of c.
 func (c *C) IsHash(h []byte) bool {
 	return bytes.Equal(h, c.h224.Sum(nil))
 }
 
-// Sum224 returns the SHA-224 checksum of c.
+// Sum224 returns the checksum of c.
 func (c *C) Sum224() Sum224 {
 	var res Sum224
 	copy(res[:], c.h224.Sum(nil))
 	return res
 }
 
+// Hash returns the algorithm used to checksum c.
+func (c *C) Hash() Hash {
+	return c.alg
+}
+
+// Codec returns the codec c is compressed with, or nil if it is not.
+func (c *C) Codec() Codec {
+	return c.codec
+}
+
+// Sealed reports whether c is sealed, see Sealer.
+func (c *C) Sealed() bool {
+	return c.sealed
+}
+
+// Compress returns a chunk holding the data of c compressed with codec, with
+// the same checksum as c.
+// If compression does not make the data smaller, or if c is already
+// compressed or sealed, c itself is returned.
+func (c *C) Compress(codec Codec) (*C, error) {
+	if c.codec != nil || c.sealed {
+		return c, nil
+	}
+	b, err := codec.Compress(c.b)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) >= len(c.b) {
+		return c, nil
+	}
+	return &C{b, c.h224, c.alg, codec, false}, nil
+}
+
+// Decompress returns a chunk holding the uncompressed data of c, or c itself
+// if it is not compressed.
+// The uncompressed data is checked against the checksum of c, and must not
+// exceed max bytes, typically the length of the chunk recorded in a
+// Metadata, otherwise ErrChunkTooLarge is returned. Sealed chunks must be
+// opened first (see KeyRing.Open), otherwise ErrChunkSealed is returned.
+func (c *C) Decompress(max int64) (*C, error) {
+	d, err := c.decompress(max)
+	if err != nil || d == c {
+		return d, err
+	}
+	h := c.alg.New()
+	h.Write(d.b)
+	if !c.IsHash(h.Sum(nil)) {
+		return nil, ErrChunkChecksum
+	}
+	return d, nil
+}
+
+// decompress is like Decompress but does not check the uncompressed data,
+// which keeps the checksum of c.
+func (c *C) decompress(max int64) (*C, error) {
+	if c.sealed {
+		return nil, ErrChunkSealed
+	}
+	if c.codec == nil {
+		return c, nil
+	}
+	b, err := c.codec.Decompress(c.b, max)
+	if err != nil {
+		return nil, err
+	}
+	return &C{b, c.h224, c.alg, nil, false}, nil
+}
+
 // NewChunk consumes r and creates a new C object of the consumed/buffered data.
 // Once created, the chunk is read-only.
 func NewChunk(r io.Reader) (*C, error) {
-	h := sha256.New224()
+	return NewChunkHash(r, SHA224)
+}
+
+// NewChunkHash is like NewChunk but checksums the data with alg instead of
+// SHA-224.
+func NewChunkHash(r io.Reader, alg Hash) (*C, error) {
+	if !alg.Available() {
+		return nil, ErrUnknownHash
+	}
+	h := alg.New()
 	tr := io.TeeReader(r, h)
 
 	b, err := ioutil.ReadAll(tr)
 	if err != nil {
 		return nil, err
 	}
-	return &C{b, h}, nil
+	return &C{b, h, alg, nil, false}, nil
+}
+
+// NewChunkCodec is like NewChunkHash but r holds data compressed with codec.
+// The data is kept compressed in the returned chunk, and is only decompressed
+// to be checksummed. If codec is nil, the data of r is taken as is.
+// ErrChunkTooLarge is returned as soon as the data, compressed or not,
+// exceeds max bytes, so that untrusted input cannot exhaust memory.
+func NewChunkCodec(r io.Reader, alg Hash, codec Codec, max int64) (*C, error) {
+	if !alg.Available() {
+		return nil, ErrUnknownHash
+	}
+
+	b, err := readMax(r, max)
+	if err != nil {
+		return nil, err
+	}
+	raw := b
+	if codec != nil {
+		if raw, err = codec.Decompress(b, max); err != nil {
+			return nil, err
+		}
+	}
+	h := alg.New()
+	h.Write(raw)
+	return &C{b, h, alg, codec, false}, nil
 }
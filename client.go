@@ -0,0 +1,216 @@
+package chunk
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// HTTPFetcher is a Fetcher getting chunks over HTTP from mirrors serving
+// them under /chunks/<hex sum>, such as a Handler.
+// Mirrors are tried in order until one of them serves the chunk. If they all
+// fail, they are tried again after a pause, up to Retries times, the pause
+// doubling every time. A chunk every mirror reports as missing is not retried.
+// Every chunk is checked against the requested checksum. Sealed chunks are
+// opened with Keys to be checked, and returned sealed; without Keys, they
+// cannot be checked and are refused like corrupt chunks, so that the next
+// mirror is tried.
+// It is thread safe as long as its fields are not modified.
+type HTTPFetcher struct {
+	Mirrors []string     // base URLs, e.g. http://example.com/store
+	Hash    Hash         // algorithm chunks are checksummed with
+	Client  *http.Client // http.DefaultClient if nil
+
+	Timeout time.Duration // per request timeout, none if 0
+	Retries int           // rounds over all mirrors after the first one
+	Backoff time.Duration // pause before the first retry, 50ms if 0
+
+	Workers int // concurrent requests in Submit, 1 if less
+
+	// MaxChunkSize bounds the length of chunks, once decompressed,
+	// DefaultMaxChunkSize if less than 1.
+	MaxChunkSize int64
+
+	Keys *KeyRing // opens sealed chunks to check them, if not nil
+}
+
+// Get implements Fetcher.
+func (hf *HTTPFetcher) Get(sum Sum224) (*C, error) {
+	return hf.GetContext(context.Background(), sum)
+}
+
+// GetContext is like Get but gives up as soon as ctx is done.
+func (hf *HTTPFetcher) GetContext(ctx context.Context, sum Sum224) (*C, error) {
+	if len(hf.Mirrors) == 0 || !hf.Hash.Available() {
+		return nil, ErrInvalidArgs
+	}
+
+	backoff := hf.Backoff
+	if backoff <= 0 {
+		backoff = retryBackoff
+	}
+	for i := 0; ; i++ {
+		var err error
+		missing := 0
+		for _, mirror := range hf.Mirrors {
+			var c *C
+			c, err = hf.get(ctx, mirror, sum)
+			if err == nil {
+				return c, nil
+			}
+			if err == ErrChunkNotFound {
+				missing++
+			}
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+		}
+		if missing == len(hf.Mirrors) {
+			return nil, ErrChunkNotFound
+		}
+		if i >= hf.Retries {
+			return nil, err
+		}
+
+		select {
+		case <-time.After(backoff):
+			backoff *= 2
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// get makes a single attempt at getting the chunk whose checksum is sum from
+// mirror.
+func (hf *HTTPFetcher) get(ctx context.Context, mirror string, sum Sum224) (*C, error) {
+	if hf.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, hf.Timeout)
+		defer cancel()
+	}
+
+	url := strings.TrimSuffix(mirror, "/") + "/chunks/" + sum.String()
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	client := hf.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		io.Copy(ioutil.Discard, resp.Body) // let the connection be reused
+		resp.Body.Close()
+	}()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return nil, ErrChunkNotFound
+	case resp.StatusCode != http.StatusOK:
+		return nil, fmt.Errorf("%w: GET %s: %s", ErrUnexpectedStatus, url, resp.Status)
+	}
+
+	var codec Codec
+	if v := resp.Header.Get(headerCodec); v != "" {
+		if codec, err = CodecByName(v); err != nil {
+			return nil, err
+		}
+	}
+	max := hf.MaxChunkSize
+	if max < 1 {
+		max = DefaultMaxChunkSize
+	}
+	if resp.Header.Get(headerSealed) == "1" {
+		if hf.Keys == nil {
+			return nil, ErrChunkSealed
+		}
+		// chunks are only compressed if it makes them smaller
+		c, err := NewSealedChunk(io.LimitReader(resp.Body, max+maxSealOverhead), hf.Hash, codec, sum)
+		if err == nil {
+			err = hf.Keys.check(c, max)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return c, nil
+	}
+	c, err := NewChunkCodec(resp.Body, hf.Hash, codec, max)
+	if err != nil {
+		return nil, err
+	}
+	if !c.IsHash(sum[:]) {
+		return nil, ErrChunkChecksum
+	}
+	return c, nil
+}
+
+// Submit fetches the chunks whose checksums are in sums, hf.Workers at a
+// time, and submits them to rec as they arrive. Duplicate checksums are only
+// fetched once.
+// It returns the first error encountered, as a *ChunkError if it concerns a
+// particular chunk, after all pending requests have been abandoned.
+func (hf *HTTPFetcher) Submit(ctx context.Context, rec *Reconstructor, sums []Sum224) error {
+	workers := hf.Workers
+	if workers < 1 {
+		workers = 1
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	feed := make(chan Sum224)
+	errs := make(chan error, workers)
+	wg := sync.WaitGroup{}
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for sum := range feed {
+				c, err := hf.GetContext(ctx, sum)
+				if err != nil {
+					err = &ChunkError{Index: -1, Sum: sum, Err: err}
+				} else {
+					err = rec.Submit(c)
+				}
+				if err != nil {
+					errs <- err
+					cancel()
+					return
+				}
+			}
+		}()
+	}
+
+	seen := make(map[Sum224]struct{})
+loop:
+	for _, sum := range sums {
+		if _, ok := seen[sum]; ok {
+			continue
+		}
+		seen[sum] = struct{}{}
+
+		select {
+		case feed <- sum:
+		case <-ctx.Done():
+			break loop
+		}
+	}
+	close(feed)
+	wg.Wait()
+
+	select {
+	case err := <-errs:
+		return err
+	default:
+	}
+	return ctx.Err()
+}
@@ -0,0 +1,172 @@
+package chunk
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHTTPFetcher(t *testing.T) {
+	data := make([]byte, 64*1024)
+	rand.New(rand.NewSource(4)).Read(data)
+
+	dir, err := ioutil.TempDir("", "chunkstore")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	fs, err := NewFileStore(dir, SHA224)
+	assert.Nil(t, err)
+
+	s := SplitStream(ioutil.NopCloser(bytes.NewReader(data)), 1024, 4, 1*time.Second)
+	for c := s.Next(); c != nil; c = s.Next() {
+		assert.Nil(t, fs.Put(c))
+	}
+	m, err := s.Metadata()
+	assert.Nil(t, err)
+
+	good := httptest.NewServer(NewHandler(fs, SHA224, 0, 0))
+	defer good.Close()
+
+	// fails the first request for every chunk
+	mu := sync.Mutex{}
+	seen := make(map[string]bool)
+	flaky := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		fail := !seen[r.URL.Path]
+		seen[r.URL.Path] = true
+		mu.Unlock()
+		if fail {
+			http.Error(w, "busy", http.StatusServiceUnavailable)
+			return
+		}
+		good.Config.Handler.ServeHTTP(w, r)
+	}))
+	defer flaky.Close()
+
+	// serves garbage
+	liar := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("garbage"))
+	}))
+	defer liar.Close()
+
+	// nothing at all
+	empty := httptest.NewServer(http.NotFoundHandler())
+	defer empty.Close()
+
+	hf := &HTTPFetcher{
+		Mirrors: []string{liar.URL, flaky.URL},
+		Hash:    SHA224,
+		Timeout: 1 * time.Second,
+		Retries: 2,
+		Backoff: 1 * time.Millisecond,
+		Workers: 8,
+	}
+
+	// through ReconstructFrom
+	out := bytes.NewBuffer(nil)
+	assert.Nil(t, ReconstructFrom(out, m, hf, 4, 0, 5*time.Second))
+	assert.Equal(t, data, out.Bytes())
+
+	// through Submit
+	buf := noopCloseWriteCloser{bytes.NewBuffer(nil), &sync.Mutex{}}
+	rec := ReconstructMetadata(buf, m, 5*time.Second)
+	assert.Nil(t, hf.Submit(context.Background(), rec, m.ChunkChecksums))
+	<-rec.Done()
+	fin, err := rec.Err()
+	assert.True(t, fin)
+	assert.Nil(t, err)
+	assert.Equal(t, data, buf.Bytes())
+
+	// missing everywhere
+	hf.Mirrors = []string{empty.URL, empty.URL}
+	_, err = hf.Get(Sum224{})
+	assert.Equal(t, ErrChunkNotFound, err)
+
+	// failing everywhere
+	hf.Mirrors = []string{liar.URL}
+	_, err = hf.Get(m.ChunkChecksums[0])
+	assert.Equal(t, ErrChunkChecksum, err)
+
+	rec = ReconstructMetadata(buf, m, 5*time.Second)
+	err = hf.Submit(context.Background(), rec, m.ChunkChecksums)
+	var ce *ChunkError
+	assert.True(t, errors.As(err, &ce))
+	assert.Equal(t, ErrChunkChecksum, ce.Err)
+
+	// per request timeout
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(1 * time.Second):
+		case <-r.Context().Done():
+		}
+	}))
+	defer slow.Close()
+	hf = &HTTPFetcher{Mirrors: []string{slow.URL}, Timeout: 50 * time.Millisecond}
+	start := time.Now()
+	_, err = hf.Get(m.ChunkChecksums[0])
+	assert.NotNil(t, err)
+	assert.True(t, time.Since(start) < 500*time.Millisecond)
+}
+
+func TestHTTPFetcherSealed(t *testing.T) {
+	data := make([]byte, 8*1024)
+	rand.New(rand.NewSource(5)).Read(data)
+
+	dir, err := ioutil.TempDir("", "chunkstore")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	fs, err := NewFileStore(dir, SHA224)
+	assert.Nil(t, err)
+
+	kr := NewKeyRing()
+	assert.Nil(t, kr.Add("k", make([]byte, 32)))
+	sp := &Splitter{Width: 1024, Sealer: &Sealer{Keys: kr, KeyID: "k"}, Timeout: time.Second}
+	s := sp.Split(ioutil.NopCloser(bytes.NewReader(data)))
+	for c := s.Next(); c != nil; c = s.Next() {
+		assert.Nil(t, fs.Put(c))
+	}
+	m, err := s.Metadata()
+	assert.Nil(t, err)
+
+	good := httptest.NewServer(NewHandler(fs, SHA224, 0, 0))
+	defer good.Close()
+	// claims to serve sealed chunks, which cannot be checked without keys
+	liar := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(headerSealed, "1")
+		w.Write([]byte("garbage"))
+	}))
+	defer liar.Close()
+
+	hf := &HTTPFetcher{Mirrors: []string{liar.URL}, Hash: SHA224}
+	_, err = hf.Get(m.ChunkChecksums[0])
+	assert.Equal(t, ErrChunkSealed, err)
+	hf.Keys = kr
+	_, err = hf.Get(m.ChunkChecksums[0])
+	assert.Equal(t, ErrChunkSealed, err)
+
+	// the liar is skipped for the honest mirror
+	hf.Mirrors = []string{liar.URL, good.URL}
+	buf := noopCloseWriteCloser{bytes.NewBuffer(nil), &sync.Mutex{}}
+	rec := ReconstructMetadata(buf, m, 5*time.Second)
+	rec.SetKeyRing(kr)
+	assert.Nil(t, hf.Submit(context.Background(), rec, m.ChunkChecksums))
+	<-rec.Done()
+	_, err = rec.Err()
+	assert.Nil(t, err)
+	assert.Equal(t, data, buf.Bytes())
+
+	// without keys, even the honest mirror cannot be trusted
+	hf.Keys = nil
+	hf.Mirrors = []string{good.URL}
+	_, err = hf.Get(m.ChunkChecksums[0])
+	assert.Equal(t, ErrChunkSealed, err)
+}
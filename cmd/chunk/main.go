@@ -0,0 +1,452 @@
+// Command chunk splits files into chunks kept in a store, and joins them back.
+//
+// Usage:
+//
+//	chunk split   -store dir -manifest file [options] file
+//	chunk join    -store dir -manifest file [-o file]
+//	chunk verify  -store dir -manifest file
+//	chunk inspect [-json] manifest
+//
+// Run chunk <command> -h for the options of every command. A file named "-"
+// stands for the standard input or output.
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/wv0m56/chunk"
+)
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+const usage = `usage: chunk <command> [options]
+
+commands:
+  split    cut a file into chunks kept in a store, and write its manifest
+  join     rebuild a file from its manifest and a store
+  verify   check that a store holds every chunk of a manifest, intact
+  inspect  print a manifest
+`
+
+// run executes the command in args and returns the exit status.
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	if len(args) < 1 {
+		fmt.Fprint(stderr, usage)
+		return 2
+	}
+
+	var cmd func(args []string, stdin io.Reader, stdout, stderr io.Writer) error
+	switch args[0] {
+	case "split":
+		cmd = split
+	case "join":
+		cmd = join
+	case "verify":
+		cmd = verify
+	case "inspect":
+		cmd = inspect
+	case "help", "-h", "-help", "--help":
+		fmt.Fprint(stdout, usage)
+		return 0
+	default:
+		fmt.Fprintf(stderr, "chunk: unknown command %q\n%s", args[0], usage)
+		return 2
+	}
+
+	err := cmd(args[1:], stdin, stdout, stderr)
+	switch {
+	case err == nil:
+		return 0
+	case err == flag.ErrHelp:
+		return 0
+	case errors.Is(err, errUsage):
+		return 2
+	default:
+		fmt.Fprintf(stderr, "chunk %s: %v\n", args[0], err)
+		return 1
+	}
+}
+
+// errUsage is returned by commands invoked with invalid flags or arguments,
+// once the problem has been reported.
+var errUsage = errors.New("usage error")
+
+func newFlagSet(name, args string, stderr io.Writer) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprintf(stderr, "usage: chunk %s [options] %s\n", name, args)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// parse parses args into fs and checks that nargs positional arguments are
+// left.
+func parse(fs *flag.FlagSet, args []string, nargs int) error {
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return err
+		}
+		return errUsage
+	}
+	if fs.NArg() != nargs {
+		fs.Usage()
+		return errUsage
+	}
+	return nil
+}
+
+func split(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	fs := newFlagSet("split", "file", stderr)
+	store := fs.String("store", "", "chunk store directory (required)")
+	manifest := fs.String("manifest", "", "manifest file to write (required)")
+	width := fs.Int64("width", 1024*1024, "fixed chunk width in bytes")
+	cdc := fs.String("cdc", "", "content-defined chunking bounds min,avg,max in bytes, instead of -width")
+	hash := fs.String("hash", "sha224", "checksum algorithm: sha224, sha256, sha512/256, blake2b-256 or xxh3-128; all truncated or padded to 224 bits")
+	codec := fs.String("codec", "", "compress chunks with this codec: gzip or flate")
+	merkle := fs.Bool("merkle", false, "record the Merkle root of the chunks")
+	merkleTop := fs.Bool("merkletop", false, "use the Merkle root of the chunks as top checksum, which -workers compute in parallel")
+	weak := fs.Bool("weak", false, "record weak checksums for delta transfers")
+	asJSON := fs.Bool("json", false, "write a JSON manifest instead of a binary one")
+	workers := fs.Int("workers", 0, "chunks processed concurrently when splitting a regular file, one per CPU if 0")
+	if err := parse(fs, args, 1); err != nil {
+		return err
+	}
+	if *store == "" || *manifest == "" {
+		fs.Usage()
+		return errUsage
+	}
+
+	sp := &chunk.Splitter{
+		Width:         *width,
+		Merkle:        *merkle,
+		MerkleTop:     *merkleTop,
+		WeakChecksums: *weak,
+		BufSize:       16,
+		Workers:       *workers,
+	}
+	if err := sp.Hash.UnmarshalText([]byte(*hash)); err != nil {
+		return err
+	}
+	if *cdc != "" {
+		bounds := strings.Split(*cdc, ",")
+		if len(bounds) != 3 {
+			return fmt.Errorf("invalid -cdc %q, want min,avg,max", *cdc)
+		}
+		var err error
+		for i, p := range []*int64{&sp.MinWidth, &sp.AvgWidth, &sp.MaxWidth} {
+			if *p, err = strconv.ParseInt(bounds[i], 10, 64); err != nil {
+				return fmt.Errorf("invalid -cdc %q: %v", *cdc, err)
+			}
+		}
+	}
+	if *codec != "" {
+		c, err := chunk.CodecByName(*codec)
+		if err != nil {
+			return err
+		}
+		sp.Codec = c
+	}
+
+	st, err := chunk.NewFileStore(*store, sp.Hash)
+	if err != nil {
+		return err
+	}
+	in, err := openInput(fs.Arg(0), stdin)
+	if err != nil {
+		return err
+	}
+	var s *chunk.Sequence
+	if fi, err := statRegular(in); err == nil {
+		// files are read concurrently, SplitAt leaves closing them to us
+		defer in.Close()
+		s = sp.SplitAtContext(context.Background(), in.(*os.File), fi.Size())
+	} else {
+		s = sp.SplitContext(context.Background(), in)
+	}
+	if s == nil {
+		in.Close()
+		return chunk.ErrInvalidArgs
+	}
+	for c := s.Next(); c != nil; c = s.Next() {
+		if err := st.Put(c); err != nil {
+			return err
+		}
+	}
+	m, err := s.Metadata()
+	if err != nil {
+		return err
+	}
+	if _, err := s.Err(); err != nil {
+		return err
+	}
+
+	b, err := encodeManifest(m, *asJSON)
+	if err != nil {
+		return err
+	}
+	if err := writeOutput(*manifest, stdout, b); err != nil {
+		return err
+	}
+	if *manifest != "-" {
+		fmt.Fprintf(stdout, "%d bytes in %d chunks, top checksum %v\n",
+			m.Size, len(m.ChunkChecksums), m.TopChecksum)
+	}
+	return nil
+}
+
+func join(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	fs := newFlagSet("join", "", stderr)
+	store := fs.String("store", "", "chunk store directory (required)")
+	manifest := fs.String("manifest", "", "manifest file (required)")
+	out := fs.String("o", "-", "output file")
+	workers := fs.Int("workers", 4, "chunks read concurrently")
+	if err := parse(fs, args, 0); err != nil {
+		return err
+	}
+	if *store == "" || *manifest == "" {
+		fs.Usage()
+		return errUsage
+	}
+
+	m, err := readManifest(*manifest, stdin)
+	if err != nil {
+		return err
+	}
+	st, err := chunk.NewFileStore(*store, m.Hash)
+	if err != nil {
+		return err
+	}
+
+	var w io.Writer = stdout
+	var f *os.File
+	if *out != "-" {
+		// written next to the output, and only renamed to it once complete
+		if f, err = createTemp(filepath.Dir(*out), ".chunk-join-"); err != nil {
+			return err
+		}
+		defer os.Remove(f.Name())
+		defer f.Close()
+		w = f
+	}
+	if err := chunk.ReconstructFromContext(context.Background(), w, m, st, *workers, 0); err != nil {
+		return err
+	}
+	if f == nil {
+		return nil
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), *out)
+}
+
+func verify(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	fs := newFlagSet("verify", "", stderr)
+	store := fs.String("store", "", "chunk store directory (required)")
+	manifest := fs.String("manifest", "", "manifest file (required)")
+	if err := parse(fs, args, 0); err != nil {
+		return err
+	}
+	if *store == "" || *manifest == "" {
+		fs.Usage()
+		return errUsage
+	}
+
+	// reading the manifest checks its consistency
+	m, err := readManifest(*manifest, stdin)
+	if err != nil {
+		return err
+	}
+	st, err := chunk.NewFileStore(*store, m.Hash)
+	if err != nil {
+		return err
+	}
+
+	// chunks are read in order, once per index, to check the top checksum
+	// along the way, unless it is the Merkle root the manifest was checked
+	// against when read
+	top := m.Hash.New()
+	bad := 0
+	checked := make(map[chunk.Sum224]error)
+	for i, sum := range m.ChunkChecksums {
+		if prev, ok := checked[sum]; ok && (prev != nil || m.MerkleTop) {
+			continue
+		}
+
+		c, err := st.Get(sum)
+		if err == nil {
+			c, err = c.Decompress(m.ChunkLengths[i])
+		}
+		if err == nil && int64(c.Reader().Len()) != m.ChunkLengths[i] {
+			err = chunk.ErrChunkChecksum
+		}
+		if err != nil {
+			fmt.Fprintf(stdout, "chunk %d %v: %v\n", i, sum, err)
+			bad++
+		} else if !m.MerkleTop {
+			io.Copy(top, c.Reader())
+		}
+		checked[sum] = err
+	}
+	if bad > 0 {
+		return fmt.Errorf("%d of %d chunks missing or corrupt", bad, len(checked))
+	}
+	if !m.MerkleTop && !m.TopChecksum.EqB(top.Sum(nil)) {
+		return chunk.ErrTopChecksum
+	}
+	fmt.Fprintf(stdout, "OK: %d chunks, %d bytes\n", len(m.ChunkChecksums), m.Size)
+	return nil
+}
+
+func inspect(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	fs := newFlagSet("inspect", "manifest", stderr)
+	asJSON := fs.Bool("json", false, "print the manifest as JSON")
+	if err := parse(fs, args, 1); err != nil {
+		return err
+	}
+
+	m, err := readManifest(fs.Arg(0), stdin)
+	if err != nil {
+		return err
+	}
+	if *asJSON {
+		b, err := json.MarshalIndent(m, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(stdout, "%s\n", b)
+		return err
+	}
+
+	tw := tabwriter.NewWriter(stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "hash:\t%v\n", m.Hash)
+	fmt.Fprintf(tw, "size:\t%d\n", m.Size)
+	switch {
+	case m.Width > 0:
+		fmt.Fprintf(tw, "width:\t%d\n", m.Width)
+	case m.MaxWidth > 0:
+		fmt.Fprintf(tw, "width:\tcontent-defined %d,%d,%d\n", m.MinWidth, m.AvgWidth, m.MaxWidth)
+	default:
+		fmt.Fprintf(tw, "width:\tcontent-defined\n")
+	}
+	fmt.Fprintf(tw, "chunks:\t%d\n", len(m.ChunkChecksums))
+	if m.MerkleTop {
+		fmt.Fprintf(tw, "top checksum:\t%v (merkle root)\n", m.TopChecksum)
+	} else {
+		fmt.Fprintf(tw, "top checksum:\t%v\n", m.TopChecksum)
+	}
+	if m.MerkleRoot != nil {
+		fmt.Fprintf(tw, "merkle root:\t%v\n", *m.MerkleRoot)
+	}
+	if m.Erasure != nil {
+		fmt.Fprintf(tw, "erasure:\t%d data + %d parity shards\n",
+			m.Erasure.DataShards, m.Erasure.ParityShards)
+	}
+	tw.Flush()
+
+	fmt.Fprintln(tw)
+	fmt.Fprintln(tw, "#\toffset\tlength\tchecksum\tcodec")
+	for i, sum := range m.ChunkChecksums {
+		codec := "-"
+		if m.ChunkCodecs != nil && m.ChunkCodecs[i] != "" {
+			codec = m.ChunkCodecs[i]
+		}
+		fmt.Fprintf(tw, "%d\t%d\t%d\t%v\t%s\n", i, m.ChunkOffsets[i], m.ChunkLengths[i], sum, codec)
+	}
+	return tw.Flush()
+}
+
+// readManifest reads a binary or JSON manifest from path.
+func readManifest(path string, stdin io.Reader) (*chunk.Metadata, error) {
+	var b []byte
+	var err error
+	if path == "-" {
+		b, err = ioutil.ReadAll(stdin)
+	} else {
+		b, err = ioutil.ReadFile(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	m := &chunk.Metadata{}
+	if bytes.HasPrefix(b, []byte("CHNK")) {
+		err = m.UnmarshalBinary(b)
+	} else {
+		err = json.Unmarshal(b, m)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return m, nil
+}
+
+func encodeManifest(m *chunk.Metadata, asJSON bool) ([]byte, error) {
+	if !asJSON {
+		return m.MarshalBinary()
+	}
+	b, err := json.MarshalIndent(m, "", "  ")
+	return append(b, '\n'), err
+}
+
+func openInput(path string, stdin io.Reader) (io.ReadCloser, error) {
+	if path == "-" {
+		return ioutil.NopCloser(stdin), nil
+	}
+	return os.Open(path)
+}
+
+// statRegular returns the FileInfo of in if it is a regular *os.File.
+func statRegular(in io.Reader) (os.FileInfo, error) {
+	f, ok := in.(*os.File)
+	if !ok {
+		return nil, errors.New("not a file")
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if !fi.Mode().IsRegular() {
+		return nil, errors.New("not a regular file")
+	}
+	return fi, nil
+}
+
+// createTemp creates a new file in dir whose name starts with prefix, with
+// the same permissions as writeOutput gives, where ioutil.TempFile would make
+// it private.
+func createTemp(dir, prefix string) (*os.File, error) {
+	for i := 0; ; i++ {
+		name := filepath.Join(dir, prefix+strconv.FormatUint(uint64(rand.Uint32()), 36))
+		f, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644)
+		if os.IsExist(err) && i < 1000 {
+			continue
+		}
+		return f, err
+	}
+}
+
+func writeOutput(path string, stdout io.Writer, b []byte) error {
+	if path == "-" {
+		_, err := stdout.Write(b)
+		return err
+	}
+	return ioutil.WriteFile(path, b, 0644)
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSplitJoinVerify(t *testing.T) {
+	dir, err := ioutil.TempDir("", "chunkcmd")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	data := make([]byte, 200*1024)
+	rand.New(rand.NewSource(1)).Read(data)
+	in := filepath.Join(dir, "in")
+	assert.Nil(t, ioutil.WriteFile(in, data, 0644))
+	store := filepath.Join(dir, "store")
+	manifest := filepath.Join(dir, "manifest")
+	out := filepath.Join(dir, "out")
+
+	for _, mode := range [][]string{
+		{"-width", "4096"},
+		{"-cdc", "1024,4096,16384", "-codec", "gzip", "-json", "-hash", "blake2b-256"},
+	} {
+		stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+		args := append([]string{"split", "-store", store, "-manifest", manifest}, mode...)
+		assert.Equal(t, 0, run(append(args, in), nil, stdout, stderr), stderr.String())
+		assert.True(t, strings.Contains(stdout.String(), "204800 bytes"))
+
+		assert.Equal(t, 0, run([]string{"join", "-store", store, "-manifest", manifest, "-o", out},
+			nil, stdout, stderr), stderr.String())
+		b, err := ioutil.ReadFile(out)
+		assert.Nil(t, err)
+		assert.Equal(t, data, b)
+		// permissions as for any file written with the umask, like in
+		fi, err := os.Stat(out)
+		assert.Nil(t, err)
+		fin, err := os.Stat(in)
+		assert.Nil(t, err)
+		assert.Equal(t, fin.Mode(), fi.Mode())
+
+		stdout.Reset()
+		assert.Equal(t, 0, run([]string{"verify", "-store", store, "-manifest", manifest},
+			nil, stdout, stderr), stderr.String())
+		assert.True(t, strings.HasPrefix(stdout.String(), "OK"))
+
+		stdout.Reset()
+		assert.Equal(t, 0, run([]string{"inspect", manifest}, nil, stdout, stderr))
+		assert.True(t, strings.Contains(stdout.String(), "size:          204800"), stdout.String())
+
+		stdout.Reset()
+		assert.Equal(t, 0, run([]string{"inspect", "-json", manifest}, nil, stdout, stderr))
+		assert.True(t, strings.Contains(stdout.String(), `"size": 204800`))
+	}
+
+	// top checksum not matching the chunks
+	m, err := readManifest(manifest, nil)
+	assert.Nil(t, err)
+	m.TopChecksum[0]++
+	bad := filepath.Join(dir, "bad-manifest")
+	b, err := m.MarshalBinary()
+	assert.Nil(t, err)
+	assert.Nil(t, ioutil.WriteFile(bad, b, 0644))
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	assert.Equal(t, 1, run([]string{"verify", "-store", store, "-manifest", bad},
+		nil, stdout, stderr))
+	assert.True(t, strings.Contains(stderr.String(), "top checksum"), stderr.String())
+	m.TopChecksum[0]--
+
+	// corrupt a chunk of the last manifest
+	var victim string
+	filepath.Walk(store, func(path string, info os.FileInfo, err error) error {
+		if strings.HasPrefix(info.Name(), m.ChunkChecksums[3].String()) {
+			victim = path
+		}
+		return nil
+	})
+	assert.Nil(t, ioutil.WriteFile(victim, []byte("garbage"), 0644))
+	stdout.Reset()
+	stderr.Reset()
+	assert.Equal(t, 1, run([]string{"verify", "-store", store, "-manifest", manifest},
+		nil, stdout, stderr))
+	assert.True(t, strings.Contains(stderr.String(), "1 of"), stderr.String())
+
+	// split from stdin to stdout
+	stdout.Reset()
+	assert.Equal(t, 0, run([]string{"split", "-store", store, "-manifest", "-", "-"},
+		bytes.NewReader(data[:1000]), stdout, stderr))
+	assert.True(t, bytes.HasPrefix(stdout.Bytes(), []byte("CHNK")))
+
+	assert.Equal(t, 2, run(nil, nil, stdout, stderr))
+	assert.Equal(t, 2, run([]string{"nope"}, nil, stdout, stderr))
+	assert.Equal(t, 2, run([]string{"join", "-store", store}, nil, stdout, stderr))
+	assert.Equal(t, 2, run([]string{"split", "-bogus"}, nil, stdout, stderr))
+}
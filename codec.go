@@ -0,0 +1,142 @@
+package chunk
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"math"
+	"sync"
+)
+
+// Codec compresses and decompresses the content of chunks.
+// Implementations must be thread safe.
+type Codec interface {
+	// Name identifies the codec in manifests and must be unique among
+	// registered codecs.
+	Name() string
+
+	// Compress returns the compressed form of b.
+	Compress(b []byte) ([]byte, error)
+
+	// Decompress returns the original form of b, as given to Compress.
+	// It must fail with ErrChunkTooLarge as soon as the original form
+	// exceeds max bytes, without decompressing the rest of b.
+	Decompress(b []byte, max int64) ([]byte, error)
+}
+
+// Codecs available out of the box.
+var (
+	Gzip  Codec = gzipCodec{}
+	Flate Codec = flateCodec{}
+)
+
+var (
+	codecsMu sync.RWMutex
+	codecs   = map[string]Codec{
+		Gzip.Name():  Gzip,
+		Flate.Name(): Flate,
+	}
+)
+
+// RegisterCodec makes c available to manifests and stores under c.Name().
+// An error is returned if the name is empty, longer than 255 bytes, contains
+// anything but ASCII letters, digits and dashes, or is already taken.
+func RegisterCodec(c Codec) error {
+	name := c.Name()
+	if name == "" || len(name) > 255 {
+		return ErrInvalidArgs
+	}
+	for _, r := range name {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-') {
+			return ErrInvalidArgs
+		}
+	}
+
+	codecsMu.Lock()
+	defer codecsMu.Unlock()
+	if _, ok := codecs[name]; ok {
+		return ErrInvalidArgs
+	}
+	codecs[name] = c
+	return nil
+}
+
+// CodecByName returns the registered Codec called name.
+func CodecByName(name string) (Codec, error) {
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+	c, ok := codecs[name]
+	if !ok {
+		return nil, ErrUnknownCodec
+	}
+	return c, nil
+}
+
+type gzipCodec struct{}
+
+func (gzipCodec) Name() string {
+	return "gzip"
+}
+
+func (gzipCodec) Compress(b []byte) ([]byte, error) {
+	buf := bytes.NewBuffer(nil)
+	w := gzip.NewWriter(buf)
+	if _, err := w.Write(b); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gzipCodec) Decompress(b []byte, max int64) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return readMax(r, max)
+}
+
+type flateCodec struct{}
+
+func (flateCodec) Name() string {
+	return "flate"
+}
+
+func (flateCodec) Compress(b []byte) ([]byte, error) {
+	buf := bytes.NewBuffer(nil)
+	w, _ := flate.NewWriter(buf, flate.DefaultCompression) // valid level
+	if _, err := w.Write(b); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (flateCodec) Decompress(b []byte, max int64) ([]byte, error) {
+	r := flate.NewReader(bytes.NewReader(b))
+	defer r.Close()
+	return readMax(r, max)
+}
+
+// readMax reads r to the end, failing with ErrChunkTooLarge as soon as more
+// than max bytes have been read.
+func readMax(r io.Reader, max int64) ([]byte, error) {
+	if max < math.MaxInt64 {
+		r = io.LimitReader(r, max+1)
+	}
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(b)) > max {
+		return nil, ErrChunkTooLarge
+	}
+	return b, nil
+}
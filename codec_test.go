@@ -0,0 +1,122 @@
+package chunk
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type upperCodec struct{}
+
+func (upperCodec) Name() string {
+	return "test-upper"
+}
+
+func (upperCodec) Compress(b []byte) ([]byte, error) {
+	return bytes.ToUpper(b[:len(b)-1]), nil
+}
+
+func (upperCodec) Decompress(b []byte, max int64) ([]byte, error) {
+	if int64(len(b)) >= max {
+		return nil, ErrChunkTooLarge
+	}
+	return append(bytes.ToLower(b), '.'), nil
+}
+
+func TestCodecs(t *testing.T) {
+	data := []byte(strings.Repeat("all work and no play makes jack a dull boy. ", 100))
+	c, err := NewChunk(bytes.NewReader(data))
+	assert.Nil(t, err)
+
+	for _, codec := range []Codec{Gzip, Flate} {
+		z, err := c.Compress(codec)
+		assert.Nil(t, err)
+		assert.Equal(t, codec, z.Codec())
+		assert.Equal(t, c.Sum224(), z.Sum224())
+		assert.True(t, z.Reader().Len() < len(data))
+
+		d, err := z.Decompress(int64(len(data)))
+		assert.Nil(t, err)
+		assert.Nil(t, d.Codec())
+		assert.Equal(t, data, d.b)
+		_, err = z.Decompress(int64(len(data)) - 1)
+		assert.Equal(t, ErrChunkTooLarge, err)
+
+		z2, err := NewChunkCodec(z.Reader(), SHA224, codec, int64(len(data)))
+		assert.Nil(t, err)
+		assert.Equal(t, c.Sum224(), z2.Sum224())
+		assert.Equal(t, z.b, z2.b)
+		_, err = NewChunkCodec(z.Reader(), SHA224, codec, 100)
+		assert.Equal(t, ErrChunkTooLarge, err)
+
+		got, err := CodecByName(codec.Name())
+		assert.Nil(t, err)
+		assert.Equal(t, codec, got)
+	}
+
+	// incompressible data is left alone
+	small, _ := NewChunk(strings.NewReader("x"))
+	z, err := small.Compress(Gzip)
+	assert.Nil(t, err)
+	assert.Equal(t, small, z)
+
+	// decompressed data not matching the checksum
+	bad := &C{[]byte("x"), c.h224, c.alg, upperCodec{}, false}
+	_, err = bad.Decompress(10)
+	assert.Equal(t, ErrChunkChecksum, err)
+
+	_, err = CodecByName("lzma")
+	assert.Equal(t, ErrUnknownCodec, err)
+	assert.Equal(t, ErrInvalidArgs, RegisterCodec(gzipCodec{}))
+	if _, err := CodecByName("test-upper"); err != nil { // first run only
+		assert.Nil(t, RegisterCodec(upperCodec{}))
+	}
+	assert.Equal(t, ErrInvalidArgs, RegisterCodec(upperCodec{}))
+	got, err := CodecByName("test-upper")
+	assert.Nil(t, err)
+	assert.Equal(t, upperCodec{}, got)
+}
+
+func TestSplitStreamCodec(t *testing.T) {
+	data := []byte(strings.Repeat("0123456789", 1000) + "tail")
+	sp := &Splitter{Width: 1000, Codec: Gzip, Timeout: 1 * time.Second}
+	s := sp.Split(ioutil.NopCloser(bytes.NewReader(data)))
+	assert.NotNil(t, s)
+
+	var chunks []*C
+	for c := s.Next(); c != nil; c = s.Next() {
+		chunks = append(chunks, c)
+	}
+	m, err := s.Metadata()
+	assert.Nil(t, err)
+	assert.Equal(t, 11, len(chunks))
+	assert.Equal(t, "gzip", m.ChunkCodecs[0])
+	assert.Equal(t, "", m.ChunkCodecs[10]) // too short to compress
+	assert.Equal(t, int64(len(data)), m.Size)
+
+	out := noopCloseWriteCloser{bytes.NewBuffer(nil), &sync.Mutex{}}
+	rec := ReconstructMetadata(out, m, 1*time.Second)
+	for i := len(chunks) - 1; i >= 0; i-- {
+		rec.Submit(chunks[i])
+	}
+	<-rec.Done()
+	fin, err := rec.Err()
+	assert.True(t, fin)
+	assert.Nil(t, err)
+	assert.Equal(t, string(data), out.String())
+
+	// corrupt compressed content
+	z, _ := Gzip.Compress([]byte(strings.Repeat("9876543210", 100)))
+	out = noopCloseWriteCloser{bytes.NewBuffer(nil), &sync.Mutex{}}
+	rec = ReconstructMetadata(out, m, 1*time.Second)
+	rec.Submit(&C{z, chunks[0].h224, SHA224, Gzip, false})
+	<-rec.Done()
+	_, err = rec.Err()
+	assert.Equal(t, ErrChunkChecksum, err.(*ChunkError).Err)
+	assert.Equal(t, 0, err.(*ChunkError).Index)
+}
@@ -0,0 +1,292 @@
+package chunk
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"sort"
+)
+
+// rollsum is the rsync rolling checksum: over a window x_0..x_l-1,
+// a = sum(x_i) and b = sum((l-i) * x_i), both mod 2^16.
+// It can be written to like a hash.Hash, and rolled along data one byte at a
+// time.
+type rollsum struct {
+	a, b uint32
+	l    uint32
+}
+
+func (r *rollsum) Write(p []byte) (int, error) {
+	for _, v := range p {
+		r.a += uint32(v)
+		r.b += r.a
+	}
+	r.l += uint32(len(p))
+	return len(p), nil
+}
+
+// roll slides the window by one byte, out leaving it and in entering it.
+func (r *rollsum) roll(out, in byte) {
+	r.a += uint32(in) - uint32(out)
+	r.b += r.a - r.l*uint32(out)
+}
+
+func (r *rollsum) Sum32() uint32 {
+	return r.a&0xffff | r.b<<16
+}
+
+// Delta records which chunks of a Metadata can be read from a basis, such as
+// an older version of the same file, and which must still be fetched.
+type Delta struct {
+	Found        map[Sum224]int64 // offset in the basis of every chunk found
+	Missing      []Sum224         // chunks not found, in order of first appearance
+	MissingBytes int64
+
+	// read only
+	alg     Hash
+	lengths map[Sum224]int64
+}
+
+// maxScanWindows caps the number of chunk lengths ScanBasis rolls a weak
+// checksum over at once.
+const maxScanWindows = 16
+
+// ScanBasis looks for the chunks of m in basis, which is size bytes long,
+// reading it once.
+// If m records content-defined chunking bounds, the basis is cut the same
+// way, and every piece is compared with the chunks of m of the same length by
+// weak checksum, then by checksum. As unchanged content is cut alike whatever
+// its offset, this finds its chunks at the cost of checksumming the basis
+// once.
+// Otherwise, rsync style, a rolling weak checksum is computed at every offset
+// of the basis for every distinct chunk length of m, up to the maxScanWindows
+// lengths shared by the most chunks, and windows whose weak checksum matches
+// one of m.WeakChecksums are compared by checksum. Fixed width chunks have at
+// most two lengths, but content-defined chunks of manifests without bounds,
+// older than version 8, are only looked for if their length is among those.
+// m must carry weak checksums (see Splitter.WeakChecksums), otherwise
+// ErrNoWeakChecksums is returned.
+func ScanBasis(basis io.ReaderAt, size int64, m *Metadata) (*Delta, error) {
+	if basis == nil || size < 0 {
+		return nil, ErrInvalidArgs
+	}
+	if err := m.validate(); err != nil {
+		return nil, err
+	}
+	if m.WeakChecksums == nil && len(m.ChunkChecksums) > 0 {
+		return nil, ErrNoWeakChecksums
+	}
+
+	d := &Delta{
+		make(map[Sum224]int64),
+		nil,
+		0,
+		m.Hash,
+		make(map[Sum224]int64),
+	}
+	// weak checksum to candidate chunks, by chunk length
+	byLength := make(map[int64]map[uint32][]Sum224)
+	count := make(map[int64]int) // distinct chunks by length
+	for i, v := range m.ChunkChecksums {
+		if _, ok := d.lengths[v]; ok {
+			continue
+		}
+		l := m.ChunkLengths[i]
+		d.lengths[v] = l
+		if byLength[l] == nil {
+			byLength[l] = make(map[uint32][]Sum224)
+		}
+		byLength[l][m.WeakChecksums[i]] = append(byLength[l][m.WeakChecksums[i]], v)
+		count[l]++
+	}
+
+	r := io.NewSectionReader(basis, 0, size)
+	if m.MaxWidth > 0 {
+		if err := d.cut(r, newCDC(m.MinWidth, m.AvgWidth, m.MaxWidth), byLength); err != nil {
+			return nil, err
+		}
+	} else {
+		var lengths []int64
+		for k := range byLength {
+			if k <= size {
+				lengths = append(lengths, k)
+			}
+		}
+		sort.Slice(lengths, func(i, j int) bool {
+			if count[lengths[i]] != count[lengths[j]] {
+				return count[lengths[i]] > count[lengths[j]]
+			}
+			return lengths[i] < lengths[j]
+		})
+		if len(lengths) > maxScanWindows {
+			lengths = lengths[:maxScanWindows]
+		}
+		if err := d.roll(r, lengths, byLength); err != nil {
+			return nil, err
+		}
+	}
+
+	missing := make(map[Sum224]struct{})
+	for _, v := range m.ChunkChecksums {
+		if _, ok := d.Found[v]; ok {
+			continue
+		}
+		if _, ok := missing[v]; ok {
+			continue
+		}
+		missing[v] = struct{}{}
+		d.Missing = append(d.Missing, v)
+		d.MissingBytes += d.lengths[v]
+	}
+	return d, nil
+}
+
+// cut cuts r into content-defined chunks with c and looks for the chunks in
+// targets, keyed by length then weak checksum, among them.
+func (d *Delta) cut(r io.Reader, c *cdc, targets map[int64]map[uint32][]Sum224) error {
+	size := readBufferSize
+	if c.max > int64(size) {
+		size = int(c.max)
+	}
+	br := bufio.NewReaderSize(r, size)
+
+	left := len(d.lengths)
+	for off := int64(0); left > 0; {
+		buf, err := br.Peek(int(c.max))
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if len(buf) == 0 {
+			return nil
+		}
+		b := buf[:c.cut(buf)]
+		if weak, ok := targets[int64(len(b))]; ok {
+			rs := &rollsum{}
+			rs.Write(b)
+			if sums, ok := weak[rs.Sum32()]; ok {
+				left -= d.match(off, sums, b)
+			}
+		}
+		off += int64(len(b))
+		if _, err := br.Discard(len(b)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// roll rolls a window of every length in lengths over r at once, looking for
+// the chunks in targets, keyed by length then weak checksum.
+func (d *Delta) roll(r io.Reader, lengths []int64, targets map[int64]map[uint32][]Sum224) error {
+	var max int64
+	left := 0
+	for _, l := range lengths {
+		if l > max {
+			max = l
+		}
+		for _, v := range targets[l] {
+			left += len(v)
+		}
+	}
+	if left == 0 {
+		return nil
+	}
+
+	br := bufio.NewReaderSize(r, readBufferSize)
+	ring := make([]byte, max) // last max bytes read, byte n at n%max
+	rs := make([]rollsum, len(lengths))
+	window := make([]byte, max)
+	for n := int64(0); ; {
+		in, err := br.ReadByte()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		for i, l := range lengths {
+			if n < l {
+				rs[i].Write([]byte{in})
+			} else {
+				rs[i].roll(ring[(n-l)%max], in)
+			}
+		}
+		ring[n%max] = in
+		n++
+
+		for i, l := range lengths {
+			if n < l {
+				continue
+			}
+			sums, ok := targets[l][rs[i].Sum32()]
+			if !ok {
+				continue
+			}
+			b := ring[(n-l)%max:]
+			if int64(len(b)) >= l {
+				b = b[:l]
+			} else {
+				b = append(append(window[:0], b...), ring[:l-int64(len(b))]...)
+			}
+			if left -= d.match(n-l, sums, b); left == 0 {
+				return nil
+			}
+		}
+	}
+}
+
+// match records the chunks among sums whose content is b as found at off of
+// the basis, unless they already are, and returns how many it recorded.
+func (d *Delta) match(off int64, sums []Sum224, b []byte) int {
+	h := d.alg.New()
+	h.Write(b)
+	var sum Sum224
+	copy(sum[:], h.Sum(nil))
+	found := 0
+	for _, v := range sums {
+		if _, ok := d.Found[v]; !ok && v.Eq(sum) {
+			d.Found[v] = off
+			found++
+		}
+	}
+	return found
+}
+
+// Fetcher returns a Fetcher reading the chunks found from basis, and getting
+// the missing ones from f. f may be nil if nothing is missing.
+func (d *Delta) Fetcher(basis io.ReaderAt, f Fetcher) Fetcher {
+	return deltaFetcher{d, basis, f}
+}
+
+type deltaFetcher struct {
+	d     *Delta
+	basis io.ReaderAt
+	f     Fetcher
+}
+
+func (df deltaFetcher) Get(sum Sum224) (*C, error) {
+	off, ok := df.d.Found[sum]
+	if !ok {
+		if df.f == nil {
+			return nil, ErrChunkNotFound
+		}
+		return df.f.Get(sum)
+	}
+
+	b := make([]byte, df.d.lengths[sum])
+	// io.EOF may come along with the last bytes of the basis
+	if n, err := df.basis.ReadAt(b, off); n < len(b) {
+		if err == nil || err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	c, err := NewChunkHash(bytes.NewReader(b), df.d.alg)
+	if err != nil {
+		return nil, err
+	}
+	if !c.Sum224().Eq(sum) {
+		return nil, ErrChunkChecksum
+	}
+	return c, nil
+}
@@ -0,0 +1,162 @@
+package chunk
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRollsum(t *testing.T) {
+	data := make([]byte, 1000)
+	rand.New(rand.NewSource(1)).Read(data)
+
+	rs := &rollsum{}
+	rs.Write(data[:100])
+	for i := 100; i < len(data); i++ {
+		rs.roll(data[i-100], data[i])
+		fresh := &rollsum{}
+		fresh.Write(data[i-99 : i+1])
+		assert.Equal(t, fresh.Sum32(), rs.Sum32())
+	}
+}
+
+func TestScanBasis(t *testing.T) {
+	rng := rand.New(rand.NewSource(2))
+	old := make([]byte, 64*1024)
+	rng.Read(old)
+
+	// new version: bytes inserted at the front and a block overwritten
+	insert := make([]byte, 777)
+	rng.Read(insert)
+	data := append(append([]byte(nil), insert...), old...)
+	rng.Read(data[20000:21000])
+
+	sp := &Splitter{
+		MinWidth:      1024,
+		AvgWidth:      4096,
+		MaxWidth:      16384,
+		WeakChecksums: true,
+		Timeout:       1 * time.Second,
+	}
+	s := sp.Split(ioutil.NopCloser(bytes.NewReader(data)))
+	var chunks []*C
+	for c := s.Next(); c != nil; c = s.Next() {
+		chunks = append(chunks, c)
+	}
+	m, err := s.Metadata()
+	assert.Nil(t, err)
+	assert.Equal(t, len(m.ChunkChecksums), len(m.WeakChecksums))
+	assert.Equal(t, []int64{1024, 4096, 16384}, []int64{m.MinWidth, m.AvgWidth, m.MaxWidth})
+
+	// weak checksums survive both manifest formats
+	b, err := m.MarshalBinary()
+	assert.Nil(t, err)
+	var m2 Metadata
+	assert.Nil(t, m2.UnmarshalBinary(b))
+	assert.Equal(t, *m, m2)
+	b, err = json.Marshal(m)
+	assert.Nil(t, err)
+	var m3 Metadata
+	assert.Nil(t, json.Unmarshal(b, &m3))
+	assert.Equal(t, *m, m3)
+
+	d, err := ScanBasis(bytes.NewReader(old), int64(len(old)), m)
+	assert.Nil(t, err)
+	assert.NotEmpty(t, d.Found)
+	assert.NotEmpty(t, d.Missing)
+	assert.True(t, d.MissingBytes < int64(len(data))/2)
+	assert.Equal(t, len(m.ChunkChecksums), len(d.Found)+len(d.Missing))
+	for i, v := range m.ChunkChecksums {
+		if off, ok := d.Found[v]; ok {
+			assert.Equal(t, data[m.ChunkOffsets[i]:m.ChunkOffsets[i]+m.ChunkLengths[i]],
+				old[off:off+m.ChunkLengths[i]])
+		}
+	}
+
+	// rebuild from the basis plus the missing chunks only
+	dir, err := ioutil.TempDir("", "chunkstore")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	fs, err := NewFileStore(dir, SHA224)
+	assert.Nil(t, err)
+	for _, c := range chunks {
+		if _, ok := d.Found[c.Sum224()]; !ok {
+			assert.Nil(t, fs.Put(c))
+		}
+	}
+	out := bytes.NewBuffer(nil)
+	assert.Nil(t, ReconstructFrom(out, m, d.Fetcher(bytes.NewReader(old), fs), 4, 0, 1*time.Second))
+	assert.Equal(t, data, out.Bytes())
+
+	// the last chunk of data ends the basis too
+	last := m.ChunkChecksums[len(m.ChunkChecksums)-1]
+	assert.Equal(t, int64(len(old))-m.ChunkLengths[len(m.ChunkLengths)-1], d.Found[last])
+	c, err := d.Fetcher(eofReaderAt{bytes.NewReader(old)}, nil).Get(last)
+	assert.Nil(t, err)
+	assert.Equal(t, last, c.Sum224())
+
+	m.WeakChecksums = nil
+	_, err = ScanBasis(bytes.NewReader(old), int64(len(old)), m)
+	assert.Equal(t, ErrNoWeakChecksums, err)
+}
+
+func TestScanBasisWindows(t *testing.T) {
+	rng := rand.New(rand.NewSource(3))
+	old := make([]byte, 64*1024)
+	rng.Read(old)
+	data := append(make([]byte, 777), old...)
+	rng.Read(data[:777])
+
+	for _, sp := range []*Splitter{
+		{Width: 1000},
+		{MinWidth: 256, AvgWidth: 1024, MaxWidth: 4096},
+	} {
+		sp.WeakChecksums = true
+		sp.Timeout = time.Second
+		s := sp.Split(ioutil.NopCloser(bytes.NewReader(data)))
+		for c := s.Next(); c != nil; c = s.Next() {
+		}
+		m, err := s.Metadata()
+		assert.Nil(t, err)
+		// as in manifests older than version 8
+		m.MinWidth, m.AvgWidth, m.MaxWidth = 0, 0, 0
+
+		d, err := ScanBasis(bytes.NewReader(old), int64(len(old)), m)
+		assert.Nil(t, err)
+		assert.NotEmpty(t, d.Found)
+		lengths := make(map[int64]struct{})
+		for i, v := range m.ChunkChecksums {
+			if off, ok := d.Found[v]; ok {
+				assert.Equal(t, data[m.ChunkOffsets[i]:m.ChunkOffsets[i]+m.ChunkLengths[i]],
+					old[off:off+m.ChunkLengths[i]])
+				lengths[m.ChunkLengths[i]] = struct{}{}
+			}
+		}
+		assert.True(t, len(lengths) <= maxScanWindows)
+		if sp.Width > 0 {
+			// every chunk but the first, shifted by the insertion
+			assert.Equal(t, len(m.ChunkChecksums)-1, len(d.Found))
+		}
+	}
+}
+
+// eofReaderAt returns io.EOF along with the last bytes of its input, as
+// io.ReaderAt allows.
+type eofReaderAt struct {
+	r *bytes.Reader
+}
+
+func (e eofReaderAt) ReadAt(b []byte, off int64) (int, error) {
+	n, err := e.r.ReadAt(b, off)
+	if err == nil && off+int64(n) == e.r.Size() {
+		err = io.EOF
+	}
+	return n, err
+}
@@ -0,0 +1,118 @@
+package chunk
+
+// Diff describes the chunks of a new Metadata relative to the chunks a
+// receiver already has, either as another Metadata or in a Store.
+// Chunks are listed once each, in order of first appearance, however many
+// times they appear.
+type Diff struct {
+	Added   []Sum224 // chunks of the new Metadata the receiver lacks
+	Removed []Sum224 // chunks of the old Metadata absent from the new one
+	Reused  []Sum224 // chunks of the new Metadata the receiver has
+
+	AddedBytes   int64
+	RemovedBytes int64
+	ReusedBytes  int64
+
+	// Plan holds one Transfer per distinct chunk of the new Metadata, in
+	// order of first appearance.
+	Plan []Transfer
+}
+
+// Transfer tells a receiver where to get a chunk of the new Metadata from,
+// and where to put it.
+type Transfer struct {
+	Sum     Sum224
+	Length  int64
+	Indexes []int // indexes of the chunk in the new Metadata
+
+	// Fetch is true if the chunk has to be downloaded, false if the
+	// receiver has it.
+	Fetch bool
+
+	// OldOffset is the offset of the chunk in the data of the old Metadata
+	// if the receiver has it there, -1 otherwise.
+	OldOffset int64
+}
+
+// DiffMetadata returns the Diff of new against old, i.e. what a receiver
+// holding the data of old needs to rebuild the data of new.
+func DiffMetadata(old, new *Metadata) (*Diff, error) {
+	if err := old.validate(); err != nil {
+		return nil, err
+	}
+	if old.Hash != new.Hash {
+		return nil, ErrHashMismatch
+	}
+
+	offsets := make(map[Sum224]int64)
+	for i, v := range old.ChunkChecksums {
+		if _, ok := offsets[v]; !ok {
+			offsets[v] = old.ChunkOffsets[i]
+		}
+	}
+
+	d, err := diff(new, func(sum Sum224) (int64, bool, error) {
+		off, ok := offsets[sum]
+		return off, ok, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	kept := make(map[Sum224]struct{})
+	for _, v := range new.ChunkChecksums {
+		kept[v] = struct{}{}
+	}
+	for i, v := range old.ChunkChecksums {
+		if _, ok := kept[v]; ok {
+			continue
+		}
+		kept[v] = struct{}{}
+		d.Removed = append(d.Removed, v)
+		d.RemovedBytes += old.ChunkLengths[i]
+	}
+	return d, nil
+}
+
+// DiffStore returns the Diff of m against the chunks held by s. Removed is
+// always empty, and so is OldOffset in the plan.
+func DiffStore(m *Metadata, s Store) (*Diff, error) {
+	return diff(m, func(sum Sum224) (int64, bool, error) {
+		ok, err := s.Has(sum)
+		return -1, ok, err
+	})
+}
+
+// diff fills in every field of a Diff but Removed, asking has whether the
+// receiver has a chunk and at which offset of its old data.
+func diff(m *Metadata, has func(sum Sum224) (int64, bool, error)) (*Diff, error) {
+	if err := m.validate(); err != nil {
+		return nil, err
+	}
+
+	d := &Diff{}
+	plan := make(map[Sum224]int) // index in d.Plan
+	for i, v := range m.ChunkChecksums {
+		if j, ok := plan[v]; ok {
+			d.Plan[j].Indexes = append(d.Plan[j].Indexes, i)
+			continue
+		}
+
+		off, ok, err := has(v)
+		if err != nil {
+			return nil, err
+		}
+		length := m.ChunkLengths[i]
+		if ok {
+			d.Reused = append(d.Reused, v)
+			d.ReusedBytes += length
+		} else {
+			d.Added = append(d.Added, v)
+			d.AddedBytes += length
+			off = -1
+		}
+		plan[v] = len(d.Plan)
+		d.Plan = append(d.Plan, Transfer{v, length, []int{i}, !ok, off})
+	}
+	return d, nil
+}
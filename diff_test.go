@@ -0,0 +1,79 @@
+package chunk
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func metadataOf(t *testing.T, data []byte, w int64) (*Metadata, []*C) {
+	s := SplitStream(ioutil.NopCloser(bytes.NewReader(data)), w, 2, 1*time.Second)
+	var chunks []*C
+	for c := s.Next(); c != nil; c = s.Next() {
+		chunks = append(chunks, c)
+	}
+	m, err := s.Metadata()
+	assert.Nil(t, err)
+	return m, chunks
+}
+
+func TestDiffMetadata(t *testing.T) {
+	old, _ := metadataOf(t, []byte("aaaabbbbccccdddd"), 4)
+	new, _ := metadataOf(t, []byte("bbbbaaaaeeeebbbbff"), 4)
+	sum := func(s string) Sum224 {
+		c, _ := NewChunk(bytes.NewReader([]byte(s)))
+		return c.Sum224()
+	}
+
+	d, err := DiffMetadata(old, new)
+	assert.Nil(t, err)
+	assert.Equal(t, []Sum224{sum("eeee"), sum("ff")}, d.Added)
+	assert.Equal(t, []Sum224{sum("cccc"), sum("dddd")}, d.Removed)
+	assert.Equal(t, []Sum224{sum("bbbb"), sum("aaaa")}, d.Reused)
+	assert.Equal(t, int64(6), d.AddedBytes)
+	assert.Equal(t, int64(8), d.RemovedBytes)
+	assert.Equal(t, int64(8), d.ReusedBytes)
+	assert.Equal(t, []Transfer{
+		{sum("bbbb"), 4, []int{0, 3}, false, 4},
+		{sum("aaaa"), 4, []int{1}, false, 0},
+		{sum("eeee"), 4, []int{2}, true, -1},
+		{sum("ff"), 2, []int{4}, true, -1},
+	}, d.Plan)
+
+	// nothing to do against itself
+	d, err = DiffMetadata(new, new)
+	assert.Nil(t, err)
+	assert.Empty(t, d.Added)
+	assert.Empty(t, d.Removed)
+	assert.Equal(t, int64(18-4), d.ReusedBytes)
+
+	old.Hash = SHA256
+	_, err = DiffMetadata(old, new)
+	assert.Equal(t, ErrHashMismatch, err)
+}
+
+func TestDiffStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "chunkstore")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	fs, err := NewFileStore(dir, SHA224)
+	assert.Nil(t, err)
+
+	m, chunks := metadataOf(t, []byte("aaaabbbbaaaacc"), 4)
+	assert.Nil(t, fs.Put(chunks[1]))
+
+	d, err := DiffStore(m, fs)
+	assert.Nil(t, err)
+	assert.Equal(t, []Sum224{chunks[0].Sum224(), chunks[3].Sum224()}, d.Added)
+	assert.Equal(t, []Sum224{chunks[1].Sum224()}, d.Reused)
+	assert.Empty(t, d.Removed)
+	assert.Equal(t, int64(6), d.AddedBytes)
+	assert.Equal(t, []int{0, 2}, d.Plan[0].Indexes)
+	assert.True(t, d.Plan[0].Fetch)
+	assert.False(t, d.Plan[1].Fetch)
+	assert.Equal(t, int64(-1), d.Plan[1].OldOffset)
+}
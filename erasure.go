@@ -0,0 +1,355 @@
+package chunk
+
+import (
+	"bytes"
+	"math"
+	"sync"
+
+	"github.com/klauspost/reedsolomon"
+)
+
+// Erasure holds the Reed-Solomon coding parameters of a Metadata.
+// The chunks are grouped in stripes of DataShards consecutive chunks, the
+// last stripe possibly being shorter. Every stripe has ParityShards parity
+// chunks, each as long as the longest chunk of the stripe, computed over the
+// stripe's chunks padded with zeroes to that length.
+// Any DataShards chunks out of the DataShards+ParityShards chunks of a stripe
+// are enough to recover the whole stripe.
+type Erasure struct {
+	DataShards   int `json:"data_shards"`
+	ParityShards int `json:"parity_shards"`
+
+	// ParityChecksums holds the ParityShards parity chunk checksums of every
+	// stripe, stripe after stripe.
+	ParityChecksums []Sum224 `json:"parity_checksums"`
+}
+
+// maxShards is the maximum number of data+parity shards per stripe.
+const maxShards = 256
+
+func (e *Erasure) valid(n int) bool {
+	if e.DataShards < 1 || e.ParityShards < 1 || e.DataShards+e.ParityShards > maxShards {
+		return false
+	}
+	return len(e.ParityChecksums) == e.stripes(n)*e.ParityShards
+}
+
+// stripes returns the number of stripes for n chunks.
+func (e *Erasure) stripes(n int) int {
+	return (n + e.DataShards - 1) / e.DataShards
+}
+
+// ErasureSequence adds Reed-Solomon parity chunks to a Sequence.
+// It is thread safe.
+type ErasureSequence struct {
+	s   *Sequence           // read only
+	k   int                 // read only
+	m   int                 // read only
+	enc reedsolomon.Encoder // read only
+
+	// r/w
+	mu     sync.Mutex
+	fin    bool
+	err    error
+	stripe []*C // data chunks of the current stripe
+	queue  []*C // parity chunks not yet returned by Next
+	parity []Sum224
+}
+
+// NewErasureSequence returns an ErasureSequence emitting dataShards data
+// chunks from s followed by parityShards parity chunks, stripe after stripe.
+// The caller must consume chunks through the returned ErasureSequence, not
+// from s directly. Parity is computed over uncompressed data, and cannot be
+// computed over sealed chunks: ErrChunkSealed is returned if s seals them.
+func NewErasureSequence(s *Sequence, dataShards, parityShards int) (*ErasureSequence, error) {
+	if s == nil || dataShards < 1 || parityShards < 1 || dataShards+parityShards > maxShards {
+		return nil, ErrInvalidArgs
+	}
+	if s.sealer != nil {
+		return nil, ErrChunkSealed
+	}
+	enc, err := reedsolomon.New(dataShards, parityShards)
+	if err != nil {
+		return nil, err
+	}
+	return &ErasureSequence{
+		s,
+		dataShards,
+		parityShards,
+		enc,
+		sync.Mutex{},
+		false,
+		nil,
+		nil,
+		nil,
+		nil,
+	}, nil
+}
+
+// Next returns the next data or parity chunk if any.
+// Data chunks are returned as soon as they are read from the underlying
+// Sequence. The parity chunks of a stripe are returned right after its last
+// data chunk.
+// Once everything has been returned, the returned chunk is nil.
+func (es *ErasureSequence) Next() *C {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+
+	if len(es.queue) > 0 {
+		c := es.queue[0]
+		es.queue = es.queue[1:]
+		return c
+	}
+	if es.fin {
+		return nil
+	}
+
+	c := es.s.Next()
+	if c != nil {
+		es.stripe = append(es.stripe, c)
+		if len(es.stripe) == es.k {
+			es.encode()
+		}
+		return c
+	}
+
+	es.fin = true
+	if fin, err := es.s.Err(); !fin || err != nil {
+		es.err = err
+		return nil
+	}
+	if len(es.stripe) > 0 {
+		es.encode()
+	}
+	if len(es.queue) > 0 {
+		c := es.queue[0]
+		es.queue = es.queue[1:]
+		return c
+	}
+	return nil
+}
+
+// assume external lock
+func (es *ErasureSequence) encode() {
+	// parity is computed over uncompressed data
+	stripe := make([]*C, len(es.stripe))
+	for i, c := range es.stripe {
+		d, err := c.decompress(math.MaxInt64) // compressed by es.s
+		if err != nil {
+			es.err = err
+			es.fin = true
+			return
+		}
+		stripe[i] = d
+	}
+
+	shards, _ := padShards(stripe, es.k, es.m)
+	if err := es.enc.Encode(shards); err != nil {
+		es.err = err
+		es.fin = true
+		return
+	}
+
+	alg := es.stripe[0].alg
+	for _, b := range shards[es.k:] {
+		c, _ := NewChunkHash(bytes.NewReader(b), alg)
+		es.queue = append(es.queue, c)
+		es.parity = append(es.parity, c.Sum224())
+	}
+	es.stripe = nil
+}
+
+// padShards returns k+m shards of equal length holding the data of the chunks
+// in stripe padded with zeroes. Shards missing from stripe are all zeroes,
+// the parity shards are allocated but left empty.
+func padShards(stripe []*C, k, m int) ([][]byte, int) {
+	size := 0
+	for _, c := range stripe {
+		if len(c.b) > size {
+			size = len(c.b)
+		}
+	}
+	shards := make([][]byte, k+m)
+	for i := range shards {
+		shards[i] = make([]byte, size)
+		if i < len(stripe) {
+			copy(shards[i], stripe[i].b)
+		}
+	}
+	return shards, size
+}
+
+// Err returns any error encountered when processing the input stream or
+// computing parity if finished==true.
+// If finished==false, err is undefined.
+func (es *ErasureSequence) Err() (finished bool, err error) {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	if es.err != nil {
+		return true, es.err
+	}
+	if !es.fin || len(es.queue) > 0 {
+		return false, nil
+	}
+	return es.s.Err()
+}
+
+// Metadata returns the metadata of the underlying Sequence along with the
+// erasure coding parameters. Must only be called after Next has returned nil,
+// otherwise an error is returned.
+func (es *ErasureSequence) Metadata() (*Metadata, error) {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	if !es.fin || len(es.queue) > 0 {
+		return nil, ErrStreamStillRunning
+	}
+	if es.err != nil {
+		return nil, es.err
+	}
+
+	m, err := es.s.Metadata()
+	if err != nil {
+		return nil, err
+	}
+	m.Erasure = &Erasure{
+		es.k,
+		es.m,
+		append([]Sum224(nil), es.parity...),
+	}
+	return m, nil
+}
+
+// stripe tracks the shards of one stripe received by a Reconstructor.
+type stripe struct {
+	shards   [][]byte // nil if missing
+	data     int      // number of data chunks in the stripe
+	have     int      // number of non-nil shards
+	complete bool     // all data chunks known, shards released
+}
+
+// erasureState lets a Reconstructor recover missing chunks from parity.
+type erasureState struct {
+	// read only
+	e           *Erasure
+	enc         reedsolomon.Encoder
+	lengths     []int64
+	parityToPos map[Sum224][]int
+
+	// r/w, guarded by the Reconstructor's mutex
+	stripes map[int]*stripe
+}
+
+func newErasureState(m *Metadata) (*erasureState, error) {
+	enc, err := reedsolomon.New(m.Erasure.DataShards, m.Erasure.ParityShards)
+	if err != nil {
+		return nil, err
+	}
+	es := &erasureState{
+		m.Erasure,
+		enc,
+		m.ChunkLengths,
+		make(map[Sum224][]int),
+		make(map[int]*stripe),
+	}
+	for i, v := range m.Erasure.ParityChecksums {
+		es.parityToPos[v] = append(es.parityToPos[v], i)
+	}
+	return es, nil
+}
+
+// assume external lock
+func (es *erasureState) stripeAt(i int) *stripe {
+	st, ok := es.stripes[i]
+	if !ok {
+		k := es.e.DataShards
+		data := len(es.lengths) - i*k
+		if data > k {
+			data = k
+		}
+		st = &stripe{make([][]byte, k+es.e.ParityShards), data, k - data, false}
+		// slots past the last chunk are implicitly all zeroes
+		for j := data; j < k; j++ {
+			st.shards[j] = []byte{}
+		}
+		es.stripes[i] = st
+	}
+	return st
+}
+
+// assume external lock
+// addData records the content of the data chunk at idx and returns the
+// stripe it belongs to.
+func (es *erasureState) addData(b []byte, idx int) int {
+	k := es.e.DataShards
+	st := es.stripeAt(idx / k)
+	if !st.complete && st.shards[idx%k] == nil {
+		st.shards[idx%k] = b
+		st.have++
+	}
+	return idx / k
+}
+
+// assume external lock
+// addParity records the content of the parity chunk at pos and returns the
+// stripe it belongs to.
+func (es *erasureState) addParity(b []byte, pos int) int {
+	m := es.e.ParityShards
+	st := es.stripeAt(pos / m)
+	if !st.complete && st.shards[es.e.DataShards+pos%m] == nil {
+		st.shards[es.e.DataShards+pos%m] = b
+		st.have++
+	}
+	return pos / m
+}
+
+// assume external lock
+// rebuild returns the content of the data chunks of stripe i which can be
+// rebuilt from parity, keyed by chunk index.
+// Once every data chunk of a stripe is known, its shards are released.
+func (es *erasureState) rebuild(i int) (map[int][]byte, error) {
+	st := es.stripeAt(i)
+	if st.complete {
+		return nil, nil
+	}
+
+	k := es.e.DataShards
+	missing := 0
+	for j := 0; j < st.data; j++ {
+		if st.shards[j] == nil {
+			missing++
+		}
+	}
+	if missing == 0 {
+		st.complete, st.shards = true, nil
+		return nil, nil
+	}
+	if st.have < k {
+		return nil, nil
+	}
+
+	size := 0
+	for j := 0; j < st.data; j++ {
+		if n := int(es.lengths[i*k+j]); n > size {
+			size = n
+		}
+	}
+	shards := make([][]byte, len(st.shards))
+	for j, v := range st.shards {
+		if v != nil {
+			shards[j] = make([]byte, size)
+			copy(shards[j], v)
+		}
+	}
+	if err := es.enc.ReconstructData(shards); err != nil {
+		return nil, err
+	}
+
+	res := make(map[int][]byte)
+	for j := 0; j < st.data; j++ {
+		if st.shards[j] == nil {
+			res[i*k+j] = shards[j][:es.lengths[i*k+j]]
+		}
+	}
+	st.complete, st.shards = true, nil
+	return res, nil
+}
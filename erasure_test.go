@@ -0,0 +1,100 @@
+package chunk
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestErasure(t *testing.T) {
+	data := make([]byte, 10*1000+123)
+	rand.New(rand.NewSource(5)).Read(data)
+
+	s := SplitStreamCDC(ioutil.NopCloser(bytes.NewReader(data)), 256, 1024, 4096, 2, 1*time.Second)
+	es, err := NewErasureSequence(s, 4, 2)
+	assert.Nil(t, err)
+
+	var all []*C
+	for c := es.Next(); c != nil; c = es.Next() {
+		all = append(all, c)
+	}
+	fin, err := es.Err()
+	assert.True(t, fin)
+	assert.Nil(t, err)
+
+	m, err := es.Metadata()
+	assert.Nil(t, err)
+	assert.Nil(t, m.validate())
+	n := len(m.ChunkChecksums)
+	stripes := (n + 3) / 4
+	assert.Equal(t, stripes*2, len(m.Erasure.ParityChecksums))
+	assert.Equal(t, n+stripes*2, len(all))
+
+	// manifest round trips
+	b, err := m.MarshalBinary()
+	assert.Nil(t, err)
+	var m2 Metadata
+	assert.Nil(t, m2.UnmarshalBinary(b))
+	assert.Equal(t, *m, m2)
+	b, err = json.Marshal(m)
+	assert.Nil(t, err)
+	var m3 Metadata
+	assert.Nil(t, json.Unmarshal(b, &m3))
+	assert.Equal(t, *m, m3)
+
+	// drop 2 chunks out of every stripe, data and parity alike
+	var kept []*C
+	for i, c := range all {
+		if i%6 != 1 && i%6 != 4 {
+			kept = append(kept, c)
+		}
+	}
+	rand.New(rand.NewSource(6)).Shuffle(len(kept), func(i, j int) {
+		kept[i], kept[j] = kept[j], kept[i]
+	})
+
+	out := noopCloseWriteCloser{bytes.NewBuffer(nil), &sync.Mutex{}}
+	rec := ReconstructMetadata(out, m, 1*time.Second)
+	for _, c := range kept {
+		// may report the end of the reconstruction, nothing else
+		if err := rec.Submit(c); err != nil {
+			assert.True(t, errors.Is(err, ErrFinishedReconstructor), err.Error())
+		}
+	}
+	<-rec.Done()
+
+	fin, err = rec.Err()
+	assert.True(t, fin)
+	assert.Nil(t, err)
+	assert.Equal(t, string(data), out.String())
+
+	// one chunk too many missing from the first stripe
+	out = noopCloseWriteCloser{bytes.NewBuffer(nil), &sync.Mutex{}}
+	rec = ReconstructMetadata(out, m, 200*time.Millisecond)
+	for i, c := range all {
+		if i > 2 {
+			rec.Submit(c)
+		}
+	}
+	<-rec.Done()
+	_, err = rec.Err()
+	assert.NotNil(t, err)
+
+	_, err = NewErasureSequence(s, 200, 100)
+	assert.Equal(t, ErrInvalidArgs, err)
+
+	// sealed chunks are rejected up front
+	kr := NewKeyRing()
+	assert.Nil(t, kr.Add("k", make([]byte, 16)))
+	sp := &Splitter{Width: 10, Sealer: &Sealer{Keys: kr, KeyID: "k"}, Timeout: time.Second}
+	sealed := sp.Split(ioutil.NopCloser(bytes.NewReader(data)))
+	_, err = NewErasureSequence(sealed, 4, 2)
+	assert.Equal(t, ErrChunkSealed, err)
+}
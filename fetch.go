@@ -0,0 +1,139 @@
+package chunk
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// Fetcher retrieves chunks by checksum, from a Store or over the network.
+// Implementations must be thread safe.
+type Fetcher interface {
+	Get(sum Sum224) (*C, error)
+}
+
+// retryBackoff is the pause before the first retry of a failed fetch. It
+// doubles on every subsequent retry.
+var retryBackoff = 50 * time.Millisecond
+
+// ReconstructFrom rebuilds the data described by m by fetching its chunks
+// from f and writes it to w.
+// At most workers chunks are fetched concurrently, and every chunk is fetched
+// up to retries+1 times before giving up. A fetched chunk whose checksum does
+// not match the requested one counts as a failed attempt.
+// Once every chunk has been written, the checksum of the whole output is
+// compared with m.TopChecksum.
+// Errors concerning a particular chunk are returned as a *ChunkError.
+// ReconstructFrom blocks until the reconstruction is over or timeout elapses.
+func ReconstructFrom(w io.Writer, m *Metadata, f Fetcher, workers, retries int,
+	timeout time.Duration) error {
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return ReconstructFromContext(ctx, w, m, f, workers, retries)
+}
+
+// ReconstructFromContext is like ReconstructFrom but runs until ctx is done
+// instead of until a timeout elapses.
+func ReconstructFromContext(ctx context.Context, w io.Writer, m *Metadata, f Fetcher,
+	workers, retries int) error {
+
+	if workers < 1 || retries < 0 || f == nil {
+		return ErrInvalidArgs
+	}
+	if err := m.validate(); err != nil {
+		return err
+	}
+	if len(m.ChunkChecksums) == 0 {
+		if !m.TopChecksum.EqB(m.Hash.New().Sum(nil)) {
+			return ErrTopChecksum
+		}
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	rec := reconstruct(ctx, cancel, nopWriteCloser{w}, m, true)
+
+	sums := make(chan Sum224)
+	errs := make(chan error, workers)
+	wg := sync.WaitGroup{}
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for sum := range sums {
+				c, err := fetchRetry(ctx, f, sum, retries)
+				if err != nil {
+					ce := &ChunkError{Index: rec.checksumToIndexes[sum][0], Sum: sum, Err: err}
+					if c != nil {
+						ce.Actual = c.Sum224()
+					}
+					err = ce
+				} else {
+					err = rec.Submit(c)
+				}
+				if err != nil {
+					errs <- err
+					cancel()
+					return
+				}
+			}
+		}()
+	}
+
+	seen := make(map[Sum224]struct{})
+feed:
+	for _, sum := range m.ChunkChecksums {
+		if _, ok := seen[sum]; ok {
+			continue
+		}
+		seen[sum] = struct{}{}
+
+		select {
+		case sums <- sum:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(sums)
+	wg.Wait()
+	<-rec.Done()
+
+	select {
+	case err := <-errs:
+		return err
+	default:
+	}
+
+	_, err := rec.Err()
+	return err
+}
+
+func fetchRetry(ctx context.Context, f Fetcher, sum Sum224, retries int) (*C, error) {
+	backoff := retryBackoff
+	for i := 0; ; i++ {
+		c, err := f.Get(sum)
+		if err == nil && !c.Sum224().Eq(sum) {
+			err = ErrChunkChecksum
+		}
+		if err == nil || i == retries {
+			return c, err
+		}
+
+		select {
+		case <-time.After(backoff):
+			backoff *= 2
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
@@ -0,0 +1,89 @@
+package chunk
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReconstructFrom(t *testing.T) {
+	data := make([]byte, 100*1024)
+	rand.New(rand.NewSource(3)).Read(data)
+	data = append(data, data[:20*1024]...) // repeated chunks
+
+	dir, err := ioutil.TempDir("", "chunkstore")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	fs, err := NewFileStore(dir, SHA224)
+	assert.Nil(t, err)
+
+	s := SplitStream(ioutil.NopCloser(bytes.NewReader(data)), 1024, 4, 1*time.Second)
+	for c := s.Next(); c != nil; c = s.Next() {
+		assert.Nil(t, fs.Put(c))
+	}
+	m, err := s.Metadata()
+	assert.Nil(t, err)
+
+	// flaky source, every chunk fails twice before succeeding
+	ff := &flakyFetcher{f: fs, fails: 2, seen: make(map[Sum224]int)}
+	out := bytes.NewBuffer(nil)
+	err = ReconstructFrom(out, m, ff, 8, 2, 5*time.Second)
+	assert.Nil(t, err)
+	assert.Equal(t, data, out.Bytes())
+
+	// not enough retries
+	ff = &flakyFetcher{f: fs, fails: 2, seen: make(map[Sum224]int)}
+	err = ReconstructFrom(bytes.NewBuffer(nil), m, ff, 8, 1, 5*time.Second)
+	assert.True(t, errors.Is(err, errFlaky))
+
+	// missing chunk
+	assert.Nil(t, fs.Delete(m.ChunkChecksums[50]))
+	err = ReconstructFrom(bytes.NewBuffer(nil), m, fs, 8, 0, 5*time.Second)
+	assert.True(t, errors.Is(err, ErrChunkNotFound))
+	var ce *ChunkError
+	assert.True(t, errors.As(err, &ce))
+	assert.Equal(t, 50, ce.Index)
+	assert.Equal(t, m.ChunkChecksums[50], ce.Sum)
+
+	// wrong top checksum
+	m2 := metadataFromFile(t, "testdata/all", 30)
+	m2.TopChecksum = m2.ChunkChecksums[0]
+	fs2, err := NewFileStore(dir, SHA224)
+	assert.Nil(t, err)
+	for _, p := range []string{"testdata/chunk1", "testdata/chunk2", "testdata/chunk3",
+		"testdata/chunk4", "testdata/chunk5"} {
+		assert.Nil(t, fs2.Put(cFromFile(t, p)))
+	}
+	err = ReconstructFrom(bytes.NewBuffer(nil), m2, fs2, 2, 0, 5*time.Second)
+	assert.Equal(t, ErrTopChecksum, err)
+
+	assert.Equal(t, ErrInvalidArgs, ReconstructFrom(out, m, fs, 0, 0, time.Second))
+}
+
+var errFlaky = errors.New("flaky")
+
+type flakyFetcher struct {
+	f     Fetcher
+	fails int
+
+	mu   sync.Mutex
+	seen map[Sum224]int
+}
+
+func (ff *flakyFetcher) Get(sum Sum224) (*C, error) {
+	ff.mu.Lock()
+	ff.seen[sum]++
+	n := ff.seen[sum]
+	ff.mu.Unlock()
+	if n <= ff.fails {
+		return nil, errFlaky
+	}
+	return ff.f.Get(sum)
+}
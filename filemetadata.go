@@ -1,9 +1,51 @@
 package chunk
 
+import "sort"
+
 // Metadata is the information required to reconstruct the original file from
 // its chunks.
+// Chunk i spans ChunkLengths[i] bytes starting at ChunkOffsets[i] of the
+// original file, which is Size bytes long.
+// All checksums are computed with Hash and are 224-bit wide, see Hash.
+// MerkleRoot, if not nil, is the root of the Merkle tree over ChunkChecksums
+// (see MerkleRoot) and lets single chunks be verified with a Proof.
+// MerkleTop reports that TopChecksum is not the checksum of the whole file
+// but MerkleRoot, which can be computed in parallel, see Splitter.MerkleTop.
+// Erasure, if not nil, describes the parity chunks computed over the chunks.
+// ChunkCodecs, if not nil, holds the name of the Codec each chunk is
+// compressed with, "" for uncompressed chunks. Checksums and lengths always
+// refer to the uncompressed data.
+// WeakChecksums, if not nil, holds the rolling checksum of every chunk, which
+// lets ScanBasis find chunks at any offset of a basis.
+// MinWidth, AvgWidth and MaxWidth, if not 0, are the bounds content-defined
+// chunks were cut with (see Splitter.MinWidth), which lets ScanBasis cut a
+// basis the same way.
 type Metadata struct {
+	Hash           Hash
 	TopChecksum    Sum224
+	MerkleRoot     *Sum224
+	MerkleTop      bool
 	ChunkChecksums []Sum224
-	Width          int64
+	ChunkOffsets   []int64
+	ChunkLengths   []int64
+	Size           int64
+	Width          int64 // 0 for content-defined chunks
+	MinWidth       int64
+	AvgWidth       int64
+	MaxWidth       int64
+	Erasure        *Erasure
+	ChunkCodecs    []string
+	WeakChecksums  []uint32
+}
+
+// Index returns the index of the chunk containing byte off of the original
+// file, or -1 if off is out of range.
+func (m *Metadata) Index(off int64) int {
+	if off < 0 || off >= m.Size {
+		return -1
+	}
+	i := sort.Search(len(m.ChunkOffsets), func(i int) bool {
+		return m.ChunkOffsets[i] > off
+	})
+	return i - 1
 }
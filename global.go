@@ -1,17 +1,90 @@
 package chunk
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+)
 
 const (
 	readBufferSize  = 1024 * 1024 // 1MB
 	writeBufferSize = 1024 * 1024
 )
 
+// Errors returned by Sequence, Reconstructor and BlindReconstructor.
+var (
+	ErrStreamStillRunning      = errors.New("input stream still running")
+	ErrResubmitSameIndex       = errors.New("processed index submitted again")
+	ErrNoChunkInMetadata       = errors.New("chunk not registered in metadata")
+	ErrFinishedReconstructor   = errors.New("finished reconstructor")
+	ErrClosedReconstructor     = errors.New("reconstructor already closed")
+	ErrChunkChecksum           = errors.New("chunk checksum error")
+	ErrTopChecksum             = errors.New("top checksum error")
+	ErrTopChecksumUnchecked    = errors.New("top checksum not checked")
+	ErrUnprocessedChunksQueued = errors.New("there are unprocessed chunks in the queue")
+	ErrHashMismatch            = errors.New("chunk hashed with a different algorithm")
+	ErrChunkSealed             = errors.New("chunk sealed or not authentic")
+	ErrHashState               = errors.New("hash state cannot be saved")
+)
+
+// Errors returned when decoding or resuming from a Checkpoint.
+var (
+	ErrCheckpointMagic        = errors.New("not a reconstruction checkpoint")
+	ErrCheckpointVersion      = errors.New("unsupported checkpoint version")
+	ErrCheckpointTruncated    = errors.New("truncated checkpoint")
+	ErrCheckpointTrailingData = errors.New("trailing data after checkpoint")
+	ErrCheckpointMismatch     = errors.New("checkpoint of another reconstruction")
+)
+
+// Errors returned when decoding or validating a Metadata.
 var (
-	errStreamStillRunning      = errors.New("input stream still running")
-	errResubmitSameIndex       = errors.New("processed index submitted again")
-	errNoChunkInMetadata       = errors.New("chunk not registered in metadata")
-	errFinishedReconstructor   = errors.New("finished reconstructor")
-	errChunkChecksum           = errors.New("chunk checksum error")
-	errUnprocessedChunksQueued = errors.New("there are unprocessed chunks in the queue")
+	ErrManifestMagic        = errors.New("not a chunk manifest")
+	ErrManifestVersion      = errors.New("unsupported manifest version")
+	ErrManifestTruncated    = errors.New("truncated manifest")
+	ErrManifestTrailingData = errors.New("trailing data after manifest")
+	ErrInvalidMetadata      = errors.New("inconsistent metadata")
+	ErrUnknownHash          = errors.New("unknown hash algorithm")
+	ErrUnknownCodec         = errors.New("unknown compression codec")
+	ErrSum224Length         = errors.New("hex string not 224-bit")
+	ErrUntrustedSigner      = errors.New("manifest signer not trusted")
+	ErrBadSignature         = errors.New("invalid manifest signature")
 )
+
+// Errors returned by stores, fetchers and readers.
+var (
+	ErrChunkNotFound    = errors.New("chunk not found in store")
+	ErrChunkTooLarge    = errors.New("chunk larger than allowed")
+	ErrInvalidArgs      = errors.New("invalid arguments")
+	ErrNegativeOffset   = errors.New("negative offset")
+	ErrInvalidWhence    = errors.New("invalid whence")
+	ErrUnknownKey       = errors.New("key not in key ring")
+	ErrNoWeakChecksums  = errors.New("metadata has no weak checksums")
+	ErrUnexpectedStatus = errors.New("unexpected HTTP status")
+)
+
+// ChunkError records an error concerning a single chunk.
+// Use errors.Is on it to test for the underlying cause.
+type ChunkError struct {
+	Index int    // index of the chunk in the stream, -1 if unknown
+	Sum   Sum224 // expected checksum of the chunk
+	Err   error
+
+	// Actual is the checksum of the chunk's content if Err is
+	// ErrChunkChecksum.
+	Actual Sum224
+}
+
+func (e *ChunkError) Error() string {
+	s := fmt.Sprintf("chunk %v", e.Sum)
+	if e.Index >= 0 {
+		s = fmt.Sprintf("chunk %d (%v)", e.Index, e.Sum)
+	}
+	if e.Err == ErrChunkChecksum {
+		return fmt.Sprintf("%v: %s: got %v", e.Err, s, e.Actual)
+	}
+	return fmt.Sprintf("%v: %s", e.Err, s)
+}
+
+// Unwrap returns the underlying cause of e.
+func (e *ChunkError) Unwrap() error {
+	return e.Err
+}
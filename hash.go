@@ -0,0 +1,149 @@
+package chunk
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding"
+	"hash"
+
+	"github.com/zeebo/xxh3"
+	"golang.org/x/crypto/blake2b"
+)
+
+// Hash identifies the algorithm used to checksum chunks and whole streams.
+// Whatever the algorithm, checksums are always 224-bit wide so that they fit
+// in a Sum224: longer digests are truncated to their leftmost 224 bits and
+// shorter ones are padded with zeroes.
+// The zero value is SHA224.
+type Hash uint8
+
+const (
+	// SHA224 is SHA-224, the default.
+	SHA224 Hash = iota
+
+	// SHA256 is SHA-256 truncated to 224 bits.
+	SHA256
+
+	// SHA512_256 is SHA-512/256 truncated to 224 bits.
+	SHA512_256
+
+	// BLAKE2b256 is BLAKE2b-256 truncated to 224 bits.
+	BLAKE2b256
+
+	// XXH3 is the 128-bit variant of the non-cryptographic XXH3 hash, padded
+	// to 224 bits. It is much faster than the others but only detects
+	// accidental corruption; never use it with untrusted data.
+	XXH3
+
+	maxHash
+)
+
+var hashNames = [maxHash]string{
+	"sha224",
+	"sha256",
+	"sha512/256",
+	"blake2b-256",
+	"xxh3-128",
+}
+
+// Available reports whether h is a known hash algorithm.
+func (h Hash) Available() bool {
+	return h < maxHash
+}
+
+// String returns the name of h as used in JSON manifests. The names of the
+// algorithms with digests longer than 224 bits are those of the full
+// algorithms, but the checksums are truncated: they match the leftmost 224
+// bits of the digests of standard tools, not the digests themselves.
+func (h Hash) String() string {
+	if !h.Available() {
+		return "unknown"
+	}
+	return hashNames[h]
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (h Hash) MarshalText() ([]byte, error) {
+	if !h.Available() {
+		return nil, ErrUnknownHash
+	}
+	return []byte(hashNames[h]), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (h *Hash) UnmarshalText(b []byte) error {
+	for i, v := range hashNames {
+		if v == string(b) {
+			*h = Hash(i)
+			return nil
+		}
+	}
+	return ErrUnknownHash
+}
+
+// New returns a new hash.Hash computing the 224-bit checksum of h.
+// It panics if h is not available.
+func (h Hash) New() hash.Hash {
+	switch h {
+	case SHA224:
+		return sha256.New224()
+	case SHA256:
+		return sum224Hash{sha256.New()}
+	case SHA512_256:
+		return sum224Hash{sha512.New512_256()}
+	case BLAKE2b256:
+		b, _ := blake2b.New256(nil) // only fails on oversized keys
+		return sum224Hash{b}
+	case XXH3:
+		return xxh3Hash{xxh3.New()}
+	}
+	panic("chunk: requested hash function is unavailable")
+}
+
+// sum224Hash truncates the digest of the embedded hash to 224 bits.
+type sum224Hash struct {
+	hash.Hash
+}
+
+func (h sum224Hash) Size() int {
+	return sha256.Size224
+}
+
+func (h sum224Hash) Sum(b []byte) []byte {
+	return h.Hash.Sum(b)[:len(b)+sha256.Size224]
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler if the embedded hash does.
+func (h sum224Hash) MarshalBinary() ([]byte, error) {
+	m, ok := h.Hash.(encoding.BinaryMarshaler)
+	if !ok {
+		return nil, ErrHashState
+	}
+	return m.MarshalBinary()
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler if the embedded hash
+// does.
+func (h sum224Hash) UnmarshalBinary(b []byte) error {
+	u, ok := h.Hash.(encoding.BinaryUnmarshaler)
+	if !ok {
+		return ErrHashState
+	}
+	return u.UnmarshalBinary(b)
+}
+
+// xxh3Hash pads the 128-bit XXH3 digest to 224 bits.
+type xxh3Hash struct {
+	*xxh3.Hasher
+}
+
+func (h xxh3Hash) Size() int {
+	return sha256.Size224
+}
+
+func (h xxh3Hash) Sum(b []byte) []byte {
+	sum := h.Sum128().Bytes()
+	var res [sha256.Size224]byte
+	copy(res[:], sum[:])
+	return append(b, res[:]...)
+}
@@ -0,0 +1,80 @@
+package chunk
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHashes(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/all")
+	assert.Nil(t, err)
+
+	tops := make(map[Sum224]Hash)
+	for alg := SHA224; alg < maxHash; alg++ {
+		assert.True(t, alg.Available())
+		assert.Equal(t, sha256.Size224, alg.New().Size())
+
+		var alg2 Hash
+		b, err := alg.MarshalText()
+		assert.Nil(t, err)
+		assert.Nil(t, alg2.UnmarshalText(b))
+		assert.Equal(t, alg, alg2)
+
+		sp := &Splitter{Hash: alg, Width: 30, BufSize: 5, Timeout: 1 * time.Second}
+		s := sp.Split(ioutil.NopCloser(bytes.NewReader(data)))
+		var chunks []*C
+		for c := s.Next(); c != nil; c = s.Next() {
+			assert.Equal(t, alg, c.Hash())
+			chunks = append(chunks, c)
+		}
+		m, err := s.Metadata()
+		assert.Nil(t, err)
+		assert.Equal(t, alg, m.Hash)
+
+		// every algorithm yields a different top checksum
+		_, ok := tops[m.TopChecksum]
+		assert.False(t, ok)
+		tops[m.TopChecksum] = alg
+
+		out := noopCloseWriteCloser{bytes.NewBuffer(nil), &sync.Mutex{}}
+		rec := ReconstructMetadata(out, m, 1*time.Second)
+		for _, c := range chunks {
+			assert.Nil(t, rec.Submit(c))
+		}
+		time.Sleep(100 * time.Millisecond)
+		top, err := rec.Sum224()
+		assert.Nil(t, err)
+		assert.Equal(t, m.TopChecksum, top)
+		assert.Equal(t, string(data), out.String())
+	}
+
+	assert.False(t, maxHash.Available())
+	assert.NotNil(t, new(Hash).UnmarshalText([]byte("md5")))
+	sp := &Splitter{Hash: maxHash, Width: 30, Timeout: 1 * time.Second}
+	assert.Nil(t, sp.Split(ioutil.NopCloser(bytes.NewReader(data))))
+}
+
+func TestHashMismatch(t *testing.T) {
+	f, err := os.Open("testdata/chunk1")
+	assert.Nil(t, err)
+	defer f.Close()
+	c, err := NewChunkHash(f, BLAKE2b256)
+	assert.Nil(t, err)
+
+	// same checksum list, different algorithm
+	out := noopCloseWriteCloser{bytes.NewBuffer(nil), &sync.Mutex{}}
+	rec := Reconstruct(out, []Sum224{c.Sum224()}, 1*time.Second)
+	assert.True(t, errors.Is(rec.Submit(c), ErrHashMismatch))
+
+	br := BlindReconstruct(out, 1*time.Second)
+	assert.True(t, errors.Is(br.Submit(c, 0), ErrHashMismatch))
+	br.Close()
+}
@@ -0,0 +1,491 @@
+package chunk
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+)
+
+// manifestMagic prefixes every binary encoded Metadata.
+var manifestMagic = [4]byte{'C', 'H', 'N', 'K'}
+
+// ManifestVersion is the version of the binary and JSON manifest formats
+// produced by Metadata. Older versions are still accepted on decode.
+//
+// Version 1 has no hash field, its checksums are always SHA-224.
+// Version 2 has no Merkle root.
+// Version 3 has no erasure coding parameters.
+// Version 4 has no chunk codecs.
+// Version 5 has no weak checksums.
+// Version 6 has no Merkle top checksums.
+// Version 7 has no content-defined chunking bounds.
+const ManifestVersion = 8
+
+// MarshalBinary implements encoding.BinaryMarshaler.
+//
+// The layout, with all integers big-endian, is:
+//
+//	magic "CHNK" | version uint8 | hash uint8 | width int64 |
+//	[min width int64 | avg width int64 | max width int64] | size int64 |
+//	top checksum | merkle uint8 | [merkle root] | chunk count uint32 |
+//	count * (chunk checksum | chunk length int64) |
+//	data shards uint16 | parity shards uint16 | parity checksums |
+//	codec count uint8 | codec count * (name length uint8 | name) |
+//	[chunk count * codec uint8] | has weak checksums uint8 |
+//	[chunk count * weak checksum uint32]
+//
+// The content-defined chunking bounds are only stored if width is 0, and are
+// 0 if unknown.
+// Merkle is 0 without Merkle root, 1 if the Merkle root follows, and 2 if the
+// top checksum is the Merkle root (see Metadata.MerkleTop), not repeated.
+// Data and parity shards are 0 without erasure coding, otherwise the number
+// of parity checksums is implied by the chunk count.
+// The codec of every chunk is only stored if the codec count is not 0, as an
+// index into the codec names starting at 1, 0 meaning uncompressed.
+// Chunk offsets are implied by the lengths and are not stored.
+// Every checksum is 224-bit wide whatever the hash, see Hash.
+func (m *Metadata) MarshalBinary() ([]byte, error) {
+	if err := m.validate(); err != nil {
+		return nil, err
+	}
+
+	buf := bytes.NewBuffer(nil)
+	buf.Write(manifestMagic[:])
+	buf.WriteByte(ManifestVersion)
+	buf.WriteByte(byte(m.Hash))
+	binary.Write(buf, binary.BigEndian, m.Width)
+	if m.Width == 0 {
+		binary.Write(buf, binary.BigEndian, [3]int64{m.MinWidth, m.AvgWidth, m.MaxWidth})
+	}
+	binary.Write(buf, binary.BigEndian, m.Size)
+	buf.Write(m.TopChecksum[:])
+	switch {
+	case m.MerkleTop:
+		buf.WriteByte(2)
+	case m.MerkleRoot != nil:
+		buf.WriteByte(1)
+		buf.Write(m.MerkleRoot[:])
+	default:
+		buf.WriteByte(0)
+	}
+	binary.Write(buf, binary.BigEndian, uint32(len(m.ChunkChecksums)))
+	for i, v := range m.ChunkChecksums {
+		buf.Write(v[:])
+		binary.Write(buf, binary.BigEndian, m.ChunkLengths[i])
+	}
+	if m.Erasure != nil {
+		binary.Write(buf, binary.BigEndian, uint16(m.Erasure.DataShards))
+		binary.Write(buf, binary.BigEndian, uint16(m.Erasure.ParityShards))
+		for _, v := range m.Erasure.ParityChecksums {
+			buf.Write(v[:])
+		}
+	} else {
+		binary.Write(buf, binary.BigEndian, uint32(0))
+	}
+
+	names, idxs := codecTable(m.ChunkCodecs)
+	buf.WriteByte(byte(len(names)))
+	for _, v := range names {
+		buf.WriteByte(byte(len(v)))
+		buf.WriteString(v)
+	}
+	if len(names) > 0 {
+		buf.Write(idxs)
+	}
+	if m.WeakChecksums != nil {
+		buf.WriteByte(1)
+		binary.Write(buf, binary.BigEndian, m.WeakChecksums)
+	} else {
+		buf.WriteByte(0)
+	}
+	return buf.Bytes(), nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler.
+// b must hold exactly one manifest as produced by MarshalBinary, otherwise an
+// error is returned and m is left untouched.
+func (m *Metadata) UnmarshalBinary(b []byte) error {
+	r := bytes.NewReader(b)
+
+	var magic [4]byte
+	if _, err := io.ReadFull(r, magic[:]); err != nil {
+		return ErrManifestTruncated
+	}
+	if magic != manifestMagic {
+		return ErrManifestMagic
+	}
+	version, err := r.ReadByte()
+	if err != nil {
+		return ErrManifestTruncated
+	}
+	if version < 1 || version > ManifestVersion {
+		return ErrManifestVersion
+	}
+
+	res := Metadata{}
+	if version >= 2 {
+		alg, err := r.ReadByte()
+		if err != nil {
+			return ErrManifestTruncated
+		}
+		res.Hash = Hash(alg)
+	}
+
+	var n uint32
+	if binary.Read(r, binary.BigEndian, &res.Width) != nil {
+		return ErrManifestTruncated
+	}
+	if version >= 8 && res.Width == 0 {
+		var bounds [3]int64
+		if binary.Read(r, binary.BigEndian, &bounds) != nil {
+			return ErrManifestTruncated
+		}
+		res.MinWidth, res.AvgWidth, res.MaxWidth = bounds[0], bounds[1], bounds[2]
+	}
+	if binary.Read(r, binary.BigEndian, &res.Size) != nil {
+		return ErrManifestTruncated
+	}
+	if _, err := io.ReadFull(r, res.TopChecksum[:]); err != nil {
+		return ErrManifestTruncated
+	}
+	if version >= 3 {
+		flag, err := r.ReadByte()
+		if err != nil {
+			return ErrManifestTruncated
+		}
+		switch flag {
+		case 0:
+		case 1:
+			res.MerkleRoot = &Sum224{}
+			if _, err := io.ReadFull(r, res.MerkleRoot[:]); err != nil {
+				return ErrManifestTruncated
+			}
+		case 2:
+			if version < 7 {
+				return ErrInvalidMetadata
+			}
+			root := res.TopChecksum
+			res.MerkleRoot = &root
+			res.MerkleTop = true
+		default:
+			return ErrInvalidMetadata
+		}
+	}
+	if binary.Read(r, binary.BigEndian, &n) != nil {
+		return ErrManifestTruncated
+	}
+	// every chunk takes up 36 bytes, don't trust n to preallocate
+	if int64(n)*36 > int64(r.Len()) {
+		return ErrManifestTruncated
+	}
+
+	var off int64
+	for i := uint32(0); i < n; i++ {
+		var sum Sum224
+		var length int64
+		if _, err := io.ReadFull(r, sum[:]); err != nil {
+			return ErrManifestTruncated
+		}
+		if binary.Read(r, binary.BigEndian, &length) != nil {
+			return ErrManifestTruncated
+		}
+		res.ChunkChecksums = append(res.ChunkChecksums, sum)
+		res.ChunkOffsets = append(res.ChunkOffsets, off)
+		res.ChunkLengths = append(res.ChunkLengths, length)
+		off += length
+	}
+	if version >= 4 {
+		var k, p uint16
+		if binary.Read(r, binary.BigEndian, &k) != nil ||
+			binary.Read(r, binary.BigEndian, &p) != nil {
+			return ErrManifestTruncated
+		}
+		if k != 0 || p != 0 {
+			res.Erasure = &Erasure{DataShards: int(k), ParityShards: int(p)}
+			if k == 0 {
+				return ErrInvalidMetadata
+			}
+			count := res.Erasure.stripes(int(n)) * int(p)
+			if int64(count)*28 > int64(r.Len()) {
+				return ErrManifestTruncated
+			}
+			for i := 0; i < count; i++ {
+				var sum Sum224
+				if _, err := io.ReadFull(r, sum[:]); err != nil {
+					return ErrManifestTruncated
+				}
+				res.Erasure.ParityChecksums = append(res.Erasure.ParityChecksums, sum)
+			}
+		}
+	}
+	if version >= 5 {
+		count, err := r.ReadByte()
+		if err != nil {
+			return ErrManifestTruncated
+		}
+		names := make([]string, count)
+		for i := range names {
+			l, err := r.ReadByte()
+			if err != nil {
+				return ErrManifestTruncated
+			}
+			name := make([]byte, l)
+			if _, err := io.ReadFull(r, name); err != nil {
+				return ErrManifestTruncated
+			}
+			names[i] = string(name)
+		}
+		if count > 0 {
+			if int64(n) > int64(r.Len()) {
+				return ErrManifestTruncated
+			}
+			res.ChunkCodecs = make([]string, n)
+			for i := range res.ChunkCodecs {
+				idx, _ := r.ReadByte()
+				if int(idx) > len(names) {
+					return ErrInvalidMetadata
+				}
+				if idx > 0 {
+					res.ChunkCodecs[i] = names[idx-1]
+				}
+			}
+		}
+	}
+	if version >= 6 {
+		flag, err := r.ReadByte()
+		if err != nil {
+			return ErrManifestTruncated
+		}
+		switch flag {
+		case 0:
+		case 1:
+			if int64(n)*4 > int64(r.Len()) {
+				return ErrManifestTruncated
+			}
+			res.WeakChecksums = make([]uint32, n)
+			binary.Read(r, binary.BigEndian, res.WeakChecksums)
+		default:
+			return ErrInvalidMetadata
+		}
+	}
+	if r.Len() > 0 {
+		return ErrManifestTrailingData
+	}
+	if err := res.validate(); err != nil {
+		return err
+	}
+
+	*m = res
+	return nil
+}
+
+type jsonManifest struct {
+	Version     int         `json:"version"`
+	Hash        *Hash       `json:"hash,omitempty"`
+	TopChecksum Sum224      `json:"top_checksum"`
+	MerkleRoot  *Sum224     `json:"merkle_root,omitempty"`
+	MerkleTop   bool        `json:"merkle_top,omitempty"`
+	Width       int64       `json:"width"`
+	MinWidth    int64       `json:"min_width,omitempty"`
+	AvgWidth    int64       `json:"avg_width,omitempty"`
+	MaxWidth    int64       `json:"max_width,omitempty"`
+	Size        int64       `json:"size"`
+	Chunks      []jsonChunk `json:"chunks"`
+	Erasure     *Erasure    `json:"erasure,omitempty"`
+}
+
+type jsonChunk struct {
+	Checksum Sum224  `json:"checksum"`
+	Offset   int64   `json:"offset"`
+	Length   int64   `json:"length"`
+	Codec    string  `json:"codec,omitempty"`
+	Weak     *uint32 `json:"weak,omitempty"`
+}
+
+// MarshalJSON implements json.Marshaler. Checksums are encoded as hex strings.
+// The hash is named as by Hash.String: "sha256" checksums, for instance, are
+// SHA-256 digests truncated to 224 bits.
+// It has a value receiver so that Metadata values, and structs embedding
+// them, are encoded as manifests too.
+func (m Metadata) MarshalJSON() ([]byte, error) {
+	if err := m.validate(); err != nil {
+		return nil, err
+	}
+
+	jm := jsonManifest{
+		ManifestVersion,
+		&m.Hash,
+		m.TopChecksum,
+		m.MerkleRoot,
+		m.MerkleTop,
+		m.Width,
+		m.MinWidth,
+		m.AvgWidth,
+		m.MaxWidth,
+		m.Size,
+		make([]jsonChunk, len(m.ChunkChecksums)),
+		m.Erasure,
+	}
+	for i, v := range m.ChunkChecksums {
+		jm.Chunks[i] = jsonChunk{v, m.ChunkOffsets[i], m.ChunkLengths[i], "", nil}
+		if m.ChunkCodecs != nil {
+			jm.Chunks[i].Codec = m.ChunkCodecs[i]
+		}
+		if m.WeakChecksums != nil {
+			jm.Chunks[i].Weak = &m.WeakChecksums[i]
+		}
+	}
+	return json.Marshal(jm)
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+// Unknown fields are rejected, and m is left untouched on error.
+func (m *Metadata) UnmarshalJSON(b []byte) error {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+
+	var jm jsonManifest
+	if err := dec.Decode(&jm); err != nil {
+		return err
+	}
+	if dec.More() {
+		return ErrManifestTrailingData
+	}
+	if jm.Version < 1 || jm.Version > ManifestVersion {
+		return ErrManifestVersion
+	}
+	if (jm.Version == 1) != (jm.Hash == nil) ||
+		(jm.Version < 3 && jm.MerkleRoot != nil) ||
+		(jm.Version < 4 && jm.Erasure != nil) ||
+		(jm.Version < 7 && jm.MerkleTop) ||
+		(jm.Version < 8 && (jm.MinWidth != 0 || jm.AvgWidth != 0 || jm.MaxWidth != 0)) {
+		return ErrInvalidMetadata
+	}
+
+	res := Metadata{
+		TopChecksum: jm.TopChecksum,
+		MerkleRoot:  jm.MerkleRoot,
+		MerkleTop:   jm.MerkleTop,
+		Erasure:     jm.Erasure,
+		Size:        jm.Size,
+		Width:       jm.Width,
+		MinWidth:    jm.MinWidth,
+		AvgWidth:    jm.AvgWidth,
+		MaxWidth:    jm.MaxWidth,
+	}
+	if jm.Hash != nil {
+		res.Hash = *jm.Hash
+	}
+	for i, v := range jm.Chunks {
+		res.ChunkChecksums = append(res.ChunkChecksums, v.Checksum)
+		res.ChunkOffsets = append(res.ChunkOffsets, v.Offset)
+		res.ChunkLengths = append(res.ChunkLengths, v.Length)
+		if v.Codec != "" {
+			if jm.Version < 5 {
+				return ErrInvalidMetadata
+			}
+			if res.ChunkCodecs == nil {
+				res.ChunkCodecs = make([]string, len(jm.Chunks))
+			}
+			res.ChunkCodecs[i] = v.Codec
+		}
+		// validate catches chunks missing a weak checksum
+		if v.Weak != nil {
+			if jm.Version < 6 {
+				return ErrInvalidMetadata
+			}
+			res.WeakChecksums = append(res.WeakChecksums, *v.Weak)
+		}
+	}
+	if err := res.validate(); err != nil {
+		return err
+	}
+
+	*m = res
+	return nil
+}
+
+// validate checks that the per-chunk fields of m agree with one another and
+// with Size, Width, the content-defined chunking bounds, MerkleRoot,
+// MerkleTop and Erasure, and that Hash and every codec are known.
+func (m *Metadata) validate() error {
+	n := len(m.ChunkChecksums)
+	if len(m.ChunkOffsets) != n || len(m.ChunkLengths) != n ||
+		m.Width < 0 || m.Size < 0 || !m.Hash.Available() {
+		return ErrInvalidMetadata
+	}
+	if (m.MinWidth != 0 || m.AvgWidth != 0 || m.MaxWidth != 0) &&
+		(m.Width != 0 || m.MinWidth < 1 || m.AvgWidth < m.MinWidth || m.MaxWidth < m.AvgWidth) {
+		return ErrInvalidMetadata
+	}
+
+	var off int64
+	for i := 0; i < n; i++ {
+		length := m.ChunkLengths[i]
+		if length < 1 || length > m.Size-off || m.ChunkOffsets[i] != off {
+			return ErrInvalidMetadata
+		}
+		if m.Width > 0 && (length > m.Width || (i < n-1 && length != m.Width)) {
+			return ErrInvalidMetadata
+		}
+		if m.MaxWidth > 0 && length > m.MaxWidth {
+			return ErrInvalidMetadata
+		}
+		off += length
+	}
+	if off != m.Size {
+		return ErrInvalidMetadata
+	}
+	if m.MerkleRoot != nil && !m.MerkleRoot.Eq(MerkleRoot(m.Hash, m.ChunkChecksums)) {
+		return ErrInvalidMetadata
+	}
+	if m.MerkleTop && (m.MerkleRoot == nil || !m.MerkleRoot.Eq(m.TopChecksum)) {
+		return ErrInvalidMetadata
+	}
+	if m.Erasure != nil && !m.Erasure.valid(n) {
+		return ErrInvalidMetadata
+	}
+	if m.WeakChecksums != nil && len(m.WeakChecksums) != n {
+		return ErrInvalidMetadata
+	}
+	if m.ChunkCodecs != nil {
+		if len(m.ChunkCodecs) != n {
+			return ErrInvalidMetadata
+		}
+		if names, _ := codecTable(m.ChunkCodecs); len(names) > 255 {
+			return ErrInvalidMetadata
+		}
+		for _, v := range m.ChunkCodecs {
+			if v == "" {
+				continue
+			}
+			if _, err := CodecByName(v); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// codecTable returns the distinct non-empty names in codecs, in order of
+// first appearance, and the index of every element of codecs into them,
+// starting at 1, 0 standing for "".
+// validate guarantees there are no more than 255 distinct names, and
+// RegisterCodec that they are at most 255 bytes long.
+func codecTable(codecs []string) ([]string, []byte) {
+	var names []string
+	pos := make(map[string]byte)
+	idxs := make([]byte, len(codecs))
+	for i, v := range codecs {
+		if v == "" {
+			continue
+		}
+		if _, ok := pos[v]; !ok {
+			names = append(names, v)
+			pos[v] = byte(len(names))
+		}
+		idxs[i] = pos[v]
+	}
+	return names, idxs
+}
@@ -0,0 +1,223 @@
+package chunk
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestManifestBinary(t *testing.T) {
+	m := metadataFromFile(t, "testdata/all", 30)
+
+	b, err := m.MarshalBinary()
+	assert.Nil(t, err)
+	assert.Equal(t, "CHNK", string(b[:4]))
+	assert.Equal(t, byte(ManifestVersion), b[4])
+
+	var m2 Metadata
+	assert.Nil(t, m2.UnmarshalBinary(b))
+	assert.Equal(t, *m, m2)
+
+	// bad magic
+	bad := append([]byte(nil), b...)
+	bad[0] = 'X'
+	assert.Equal(t, ErrManifestMagic, m2.UnmarshalBinary(bad))
+
+	// unknown version
+	bad = append([]byte(nil), b...)
+	bad[4] = ManifestVersion + 1
+	assert.Equal(t, ErrManifestVersion, m2.UnmarshalBinary(bad))
+
+	// truncated
+	assert.Equal(t, ErrManifestTruncated, m2.UnmarshalBinary(b[:len(b)-1]))
+	assert.Equal(t, ErrManifestTruncated, m2.UnmarshalBinary(b[:3]))
+
+	// trailing garbage
+	assert.Equal(t, ErrManifestTrailingData, m2.UnmarshalBinary(append(b, 0)))
+
+	// size disagreeing with chunk lengths
+	bad = append([]byte(nil), b...)
+	bad[21]++
+	assert.Equal(t, ErrInvalidMetadata, m2.UnmarshalBinary(bad))
+
+	// unknown hash
+	bad = append([]byte(nil), b...)
+	bad[5] = 0xff
+	assert.Equal(t, ErrInvalidMetadata, m2.UnmarshalBinary(bad))
+
+	// m2 untouched by failed decodes
+	assert.Equal(t, *m, m2)
+
+	// version 1 has no hash byte and implies SHA-224
+	v1 := append([]byte("CHNK\x01"), b[6:50]...)
+	v1 = append(v1, b[51:len(b)-6]...)
+	var m3 Metadata
+	assert.Nil(t, m3.UnmarshalBinary(v1))
+	assert.Equal(t, *m, m3)
+}
+
+func TestManifestJSON(t *testing.T) {
+	m := metadataFromFile(t, "testdata/all", 30)
+
+	b, err := json.Marshal(m)
+	assert.Nil(t, err)
+	assert.True(t, strings.Contains(string(b),
+		`"top_checksum":"6bcc3cb34fce8aeddf37c797df54ea04fe8a35363904463050dbfd87"`))
+	assert.True(t, strings.Contains(string(b),
+		`{"checksum":"fcbd8149fb4c6fcb49770ae28e5720e2f7e74e7bc60989829ccf68d6","offset":120,"length":9}`))
+
+	var m2 Metadata
+	assert.Nil(t, json.Unmarshal(b, &m2))
+	assert.Equal(t, *m, m2)
+
+	// values are encoded the same
+	b2, err := json.Marshal(struct{ M Metadata }{*m})
+	assert.Nil(t, err)
+	assert.Equal(t, `{"M":`+string(b)+`}`, string(b2))
+
+	s := string(b)
+	assert.True(t, strings.Contains(s, `"version":8,"hash":"sha224"`))
+	assert.NotNil(t, json.Unmarshal([]byte(strings.Replace(s, `"version":8`, `"version":9`, 1)), &m2))
+	assert.NotNil(t, json.Unmarshal([]byte(strings.Replace(s, `"sha224"`, `"md5"`, 1)), &m2))
+	assert.NotNil(t, json.Unmarshal([]byte(strings.Replace(s, `"version":8`, `"version":1`, 1)), &m2))
+	assert.NotNil(t, json.Unmarshal([]byte(strings.Replace(s, `"size":129`, `"size":128`, 1)), &m2))
+	assert.NotNil(t, json.Unmarshal([]byte(strings.Replace(s, `"offset":120`, `"offset":121`, 1)), &m2))
+	assert.NotNil(t, json.Unmarshal([]byte(strings.Replace(s, `"width"`, `"depth"`, 1)), &m2))
+	assert.NotNil(t, json.Unmarshal([]byte(strings.Replace(s, `"6bcc`, `"zzcc`, 1)), &m2))
+	assert.Equal(t, *m, m2)
+
+	// version 1 has no hash field and implies SHA-224
+	v1 := strings.Replace(s, `"version":8,"hash":"sha224"`, `"version":1`, 1)
+	var m3 Metadata
+	assert.Nil(t, json.Unmarshal([]byte(v1), &m3))
+	assert.Equal(t, *m, m3)
+}
+
+func TestManifestMerkleRoot(t *testing.T) {
+	m := metadataFromFile(t, "testdata/all", 30)
+	root := MerkleRoot(m.Hash, m.ChunkChecksums)
+	m.MerkleRoot = &root
+
+	b, err := m.MarshalBinary()
+	assert.Nil(t, err)
+	var m2 Metadata
+	assert.Nil(t, m2.UnmarshalBinary(b))
+	assert.Equal(t, root, *m2.MerkleRoot)
+
+	b, err = json.Marshal(m)
+	assert.Nil(t, err)
+	var m3 Metadata
+	assert.Nil(t, json.Unmarshal(b, &m3))
+	assert.Equal(t, root, *m3.MerkleRoot)
+
+	// root not matching the chunk checksums
+	m.MerkleRoot = &m.TopChecksum
+	_, err = m.MarshalBinary()
+	assert.Equal(t, ErrInvalidMetadata, err)
+}
+
+func TestManifestMerkleTop(t *testing.T) {
+	m := metadataFromFile(t, "testdata/all", 30)
+	root := MerkleRoot(m.Hash, m.ChunkChecksums)
+	m.TopChecksum, m.MerkleRoot, m.MerkleTop = root, &root, true
+
+	b, err := m.MarshalBinary()
+	assert.Nil(t, err)
+	var m2 Metadata
+	assert.Nil(t, m2.UnmarshalBinary(b))
+	assert.Equal(t, *m, m2)
+
+	b, err = json.Marshal(m)
+	assert.Nil(t, err)
+	assert.True(t, strings.Contains(string(b), `"merkle_top":true`))
+	var m3 Metadata
+	assert.Nil(t, json.Unmarshal(b, &m3))
+	assert.Equal(t, *m, m3)
+
+	// Merkle top checksums not allowed before version 7
+	s := strings.Replace(string(b), `"version":8`, `"version":6`, 1)
+	assert.Equal(t, ErrInvalidMetadata, json.Unmarshal([]byte(s), &m3))
+
+	// top checksum not being the root
+	m.TopChecksum[0]++
+	_, err = m.MarshalBinary()
+	assert.Equal(t, ErrInvalidMetadata, err)
+}
+
+func TestManifestBounds(t *testing.T) {
+	sp := &Splitter{MinWidth: 8, AvgWidth: 16, MaxWidth: 64, Timeout: time.Second}
+	f, err := os.Open("testdata/all")
+	assert.Nil(t, err)
+	s := sp.Split(f)
+	for c := s.Next(); c != nil; c = s.Next() {
+	}
+	m, err := s.Metadata()
+	assert.Nil(t, err)
+
+	b, err := m.MarshalBinary()
+	assert.Nil(t, err)
+	var m2 Metadata
+	assert.Nil(t, m2.UnmarshalBinary(b))
+	assert.Equal(t, *m, m2)
+
+	b, err = json.Marshal(m)
+	assert.Nil(t, err)
+	assert.True(t, strings.Contains(string(b), `"width":0,"min_width":8,"avg_width":16,"max_width":64`))
+	var m3 Metadata
+	assert.Nil(t, json.Unmarshal(b, &m3))
+	assert.Equal(t, *m, m3)
+
+	// bounds not allowed before version 8
+	js := strings.Replace(string(b), `"version":8`, `"version":7`, 1)
+	assert.Equal(t, ErrInvalidMetadata, json.Unmarshal([]byte(js), &m3))
+
+	// chunk longer than the bounds allow
+	m.MaxWidth = m.AvgWidth
+	_, err = m.MarshalBinary()
+	assert.Equal(t, ErrInvalidMetadata, err)
+}
+
+func TestManifestCodecs(t *testing.T) {
+	m := metadataFromFile(t, "testdata/all", 30)
+	m.ChunkCodecs = []string{"gzip", "", "flate", "gzip", ""}
+
+	b, err := m.MarshalBinary()
+	assert.Nil(t, err)
+	var m2 Metadata
+	assert.Nil(t, m2.UnmarshalBinary(b))
+	assert.Equal(t, *m, m2)
+
+	b, err = json.Marshal(m)
+	assert.Nil(t, err)
+	assert.True(t, strings.Contains(string(b), `"length":30,"codec":"flate"}`))
+	var m3 Metadata
+	assert.Nil(t, json.Unmarshal(b, &m3))
+	assert.Equal(t, *m, m3)
+
+	// codecs not allowed before version 5
+	s := strings.Replace(string(b), `"version":8`, `"version":4`, 1)
+	assert.Equal(t, ErrInvalidMetadata, json.Unmarshal([]byte(s), &m3))
+
+	m.ChunkCodecs[1] = "lzma"
+	_, err = m.MarshalBinary()
+	assert.Equal(t, ErrUnknownCodec, err)
+
+	m.ChunkCodecs = m.ChunkCodecs[1:]
+	_, err = m.MarshalBinary()
+	assert.Equal(t, ErrInvalidMetadata, err)
+}
+
+func metadataFromFile(t *testing.T, path string, w int64) *Metadata {
+	f, err := os.Open(path)
+	assert.Nil(t, err)
+	s := SplitStream(f, w, 1, 1*time.Second)
+	for c := s.Next(); c != nil; c = s.Next() {
+	}
+	m, err := s.Metadata()
+	assert.Nil(t, err)
+	return m
+}
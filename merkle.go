@@ -0,0 +1,116 @@
+package chunk
+
+import "math/bits"
+
+// Merkle trees follow the RFC 6962 layout: leaves and interior nodes are
+// hashed with distinct prefixes, and a tree of n leaves is split at the
+// largest power of two smaller than n.
+const (
+	merkleLeafPrefix = 0x00
+	merkleNodePrefix = 0x01
+)
+
+// MerkleRoot returns the root of the Merkle tree built with alg over the chunk
+// checksums in sums. The root of an empty tree is the checksum of no data.
+func MerkleRoot(alg Hash, sums []Sum224) Sum224 {
+	if len(sums) == 0 {
+		var res Sum224
+		copy(res[:], alg.New().Sum(nil))
+		return res
+	}
+	return merkleTree(alg, sums)
+}
+
+func merkleTree(alg Hash, sums []Sum224) Sum224 {
+	if len(sums) == 1 {
+		return merkleLeaf(alg, sums[0])
+	}
+	k := merkleSplit(len(sums))
+	return merkleNode(alg, merkleTree(alg, sums[:k]), merkleTree(alg, sums[k:]))
+}
+
+// merkleSplit returns the largest power of two smaller than n (n > 1).
+func merkleSplit(n int) int {
+	return 1 << uint(bits.Len(uint(n-1))-1)
+}
+
+func merkleLeaf(alg Hash, sum Sum224) Sum224 {
+	h := alg.New()
+	h.Write([]byte{merkleLeafPrefix})
+	h.Write(sum[:])
+	var res Sum224
+	copy(res[:], h.Sum(nil))
+	return res
+}
+
+func merkleNode(alg Hash, l, r Sum224) Sum224 {
+	h := alg.New()
+	h.Write([]byte{merkleNodePrefix})
+	h.Write(l[:])
+	h.Write(r[:])
+	var res Sum224
+	copy(res[:], h.Sum(nil))
+	return res
+}
+
+// Proof is the inclusion proof of chunk Index among Count chunks, i.e. the
+// sibling hashes on the way from the chunk's leaf up to the Merkle root.
+type Proof struct {
+	Hash  Hash
+	Index int
+	Count int
+	Path  []Sum224
+}
+
+// Proof returns the inclusion proof of chunk i in the Merkle tree over
+// m.ChunkChecksums.
+func (m *Metadata) Proof(i int) (*Proof, error) {
+	if i < 0 || i >= len(m.ChunkChecksums) {
+		return nil, ErrNoChunkInMetadata
+	}
+	return &Proof{
+		m.Hash,
+		i,
+		len(m.ChunkChecksums),
+		merklePath(m.Hash, i, m.ChunkChecksums),
+	}, nil
+}
+
+func merklePath(alg Hash, i int, sums []Sum224) []Sum224 {
+	if len(sums) < 2 {
+		return nil
+	}
+	k := merkleSplit(len(sums))
+	if i < k {
+		return append(merklePath(alg, i, sums[:k]), merkleTree(alg, sums[k:]))
+	}
+	return append(merklePath(alg, i-k, sums[k:]), merkleTree(alg, sums[:k]))
+}
+
+// Verify reports whether p proves that a chunk with checksum sum sits at
+// p.Index in the tree whose root is root.
+func (p *Proof) Verify(root, sum Sum224) bool {
+	if !p.Hash.Available() || p.Index < 0 || p.Index >= p.Count {
+		return false
+	}
+
+	fn, sn := p.Index, p.Count-1
+	r := merkleLeaf(p.Hash, sum)
+	for _, v := range p.Path {
+		if sn == 0 {
+			return false
+		}
+		if fn&1 == 1 || fn == sn {
+			r = merkleNode(p.Hash, v, r)
+			for fn&1 == 0 && fn != 0 {
+				fn >>= 1
+				sn >>= 1
+			}
+		} else {
+			r = merkleNode(p.Hash, r, v)
+		}
+		fn >>= 1
+		sn >>= 1
+	}
+	return sn == 0 && r.Eq(root)
+}
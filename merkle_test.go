@@ -0,0 +1,63 @@
+package chunk
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMerkleProof(t *testing.T) {
+	for n := 1; n <= 17; n++ {
+		m := &Metadata{Hash: SHA256}
+		for i := 0; i < n; i++ {
+			m.ChunkChecksums = append(m.ChunkChecksums, Sum224{byte(i), byte(n)})
+		}
+		root := MerkleRoot(m.Hash, m.ChunkChecksums)
+
+		for i := 0; i < n; i++ {
+			p, err := m.Proof(i)
+			assert.Nil(t, err)
+			assert.True(t, p.Verify(root, m.ChunkChecksums[i]), "n=%d i=%d", n, i)
+
+			// wrong chunk, wrong root, wrong position
+			assert.False(t, p.Verify(root, Sum224{0xff}))
+			assert.False(t, p.Verify(m.ChunkChecksums[i], m.ChunkChecksums[i]))
+			if n > 1 {
+				p.Index = (i + 1) % n
+				assert.False(t, p.Verify(root, m.ChunkChecksums[i]))
+			}
+		}
+
+		_, err := m.Proof(n)
+		assert.NotNil(t, err)
+	}
+}
+
+func TestSplitterMerkle(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/all")
+	assert.Nil(t, err)
+
+	sp := &Splitter{Merkle: true, Width: 30, BufSize: 5, Timeout: 1 * time.Second}
+	s := sp.Split(ioutil.NopCloser(bytes.NewReader(data)))
+	var chunks []*C
+	for c := s.Next(); c != nil; c = s.Next() {
+		chunks = append(chunks, c)
+	}
+	m, err := s.Metadata()
+	assert.Nil(t, err)
+	assert.NotNil(t, m.MerkleRoot)
+
+	// a receiver holding only the root can check any single chunk
+	root := *m.MerkleRoot
+	for i, c := range chunks {
+		p, err := m.Proof(i)
+		assert.Nil(t, err)
+		assert.True(t, p.Verify(root, c.Sum224()))
+	}
+
+	// no root unless requested
+	assert.Nil(t, metadataFromFile(t, "testdata/all", 30).MerkleRoot)
+}
@@ -0,0 +1,143 @@
+package chunk
+
+import (
+	"container/list"
+	"io"
+	"sync"
+)
+
+// Reader gives random access to the data described by a Metadata, fetching
+// only the chunks covering the requested ranges and caching the most
+// recently used ones.
+// It implements io.ReaderAt, io.ReadSeeker and is thread safe.
+type Reader struct {
+	m *Metadata // read only
+	f Fetcher   // read only
+
+	// r/w
+	mu    sync.Mutex
+	off   int64
+	cap   int
+	lru   *list.List // of *C, most recently used at the front
+	cache map[Sum224]*list.Element
+}
+
+// NewReader returns a Reader over the data described by m, fetching chunks
+// from f and keeping up to cacheSize of them in memory.
+// The returned Reader is nil if m is inconsistent, f==nil or cacheSize<1.
+func NewReader(m *Metadata, f Fetcher, cacheSize int) *Reader {
+	if f == nil || cacheSize < 1 || m.validate() != nil {
+		return nil
+	}
+	return &Reader{
+		m,
+		f,
+		sync.Mutex{},
+		0,
+		cacheSize,
+		list.New(),
+		make(map[Sum224]*list.Element),
+	}
+}
+
+// Size returns the length of the underlying data.
+func (r *Reader) Size() int64 {
+	return r.m.Size
+}
+
+// ReadAt implements io.ReaderAt.
+func (r *Reader) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, ErrNegativeOffset
+	}
+
+	n := 0
+	for n < len(p) {
+		i := r.m.Index(off)
+		if i < 0 {
+			return n, io.EOF
+		}
+		c, err := r.chunk(i)
+		if err != nil {
+			return n, err
+		}
+		k := copy(p[n:], c.b[off-r.m.ChunkOffsets[i]:])
+		n += k
+		off += int64(k)
+	}
+	return n, nil
+}
+
+// Read implements io.Reader.
+func (r *Reader) Read(p []byte) (int, error) {
+	r.mu.Lock()
+	off := r.off
+	r.mu.Unlock()
+
+	n, err := r.ReadAt(p, off)
+	if n > 0 && err == io.EOF {
+		err = nil
+	}
+
+	r.mu.Lock()
+	r.off = off + int64(n)
+	r.mu.Unlock()
+	return n, err
+}
+
+// Seek implements io.Seeker.
+func (r *Reader) Seek(offset int64, whence int) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.off
+	case io.SeekEnd:
+		offset += r.m.Size
+	default:
+		return 0, ErrInvalidWhence
+	}
+	if offset < 0 {
+		return 0, ErrNegativeOffset
+	}
+	r.off = offset
+	return offset, nil
+}
+
+// chunk returns chunk i from the cache, or fetches it.
+func (r *Reader) chunk(i int) (*C, error) {
+	sum := r.m.ChunkChecksums[i]
+
+	r.mu.Lock()
+	if e, ok := r.cache[sum]; ok {
+		r.lru.MoveToFront(e)
+		r.mu.Unlock()
+		return e.Value.(*C), nil
+	}
+	r.mu.Unlock()
+
+	c, err := r.f.Get(sum)
+	if err == nil {
+		c, err = c.Decompress(r.m.ChunkLengths[i])
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !c.Sum224().Eq(sum) || int64(len(c.b)) != r.m.ChunkLengths[i] {
+		return nil, ErrChunkChecksum
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.cache[sum]; !ok {
+		r.cache[sum] = r.lru.PushFront(c)
+		if r.lru.Len() > r.cap {
+			e := r.lru.Back()
+			r.lru.Remove(e)
+			delete(r.cache, e.Value.(*C).Sum224())
+		}
+	}
+	return c, nil
+}
@@ -0,0 +1,101 @@
+package chunk
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReader(t *testing.T) {
+	data := make([]byte, 64*1024)
+	rand.New(rand.NewSource(4)).Read(data)
+
+	dir, err := ioutil.TempDir("", "chunkstore")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	fs, err := NewFileStore(dir, SHA224)
+	assert.Nil(t, err)
+
+	s := SplitStreamCDC(ioutil.NopCloser(bytes.NewReader(data)), 512, 2048, 8192, 4, 1*time.Second)
+	for c := s.Next(); c != nil; c = s.Next() {
+		assert.Nil(t, fs.Put(c))
+	}
+	m, err := s.Metadata()
+	assert.Nil(t, err)
+
+	cf := &countingFetcher{f: fs}
+	r := NewReader(m, cf, 2)
+	assert.NotNil(t, r)
+	assert.Equal(t, int64(len(data)), r.Size())
+
+	// a small range inside a single chunk fetches only that chunk
+	p := make([]byte, 10)
+	off := m.ChunkOffsets[3] + 1
+	n, err := r.ReadAt(p, off)
+	assert.Nil(t, err)
+	assert.Equal(t, 10, n)
+	assert.Equal(t, data[off:off+10], p)
+	assert.Equal(t, 1, cf.count())
+
+	// cached
+	_, err = r.ReadAt(p, off)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, cf.count())
+
+	// range spanning chunk boundaries
+	p = make([]byte, 5000)
+	n, err = r.ReadAt(p, 30000)
+	assert.Nil(t, err)
+	assert.Equal(t, 5000, n)
+	assert.Equal(t, data[30000:35000], p)
+
+	// past the end
+	n, err = r.ReadAt(p, int64(len(data))-100)
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, 100, n)
+	assert.Equal(t, data[len(data)-100:], p[:100])
+
+	// seek then read the rest
+	pos, err := r.Seek(-1000, io.SeekEnd)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(len(data)-1000), pos)
+	rest, err := ioutil.ReadAll(r)
+	assert.Nil(t, err)
+	assert.Equal(t, data[len(data)-1000:], rest)
+
+	_, err = r.Seek(0, io.SeekStart)
+	assert.Nil(t, err)
+	all, err := ioutil.ReadAll(r)
+	assert.Nil(t, err)
+	assert.Equal(t, data, all)
+
+	_, err = r.Seek(-1, io.SeekStart)
+	assert.NotNil(t, err)
+	assert.Nil(t, NewReader(m, cf, 0))
+}
+
+type countingFetcher struct {
+	f  Fetcher
+	mu sync.Mutex
+	n  int
+}
+
+func (cf *countingFetcher) Get(sum Sum224) (*C, error) {
+	cf.mu.Lock()
+	cf.n++
+	cf.mu.Unlock()
+	return cf.f.Get(sum)
+}
+
+func (cf *countingFetcher) count() int {
+	cf.mu.Lock()
+	defer cf.mu.Unlock()
+	return cf.n
+}
@@ -2,8 +2,8 @@ package chunk
 
 import (
 	"bufio"
+	"bytes"
 	"context"
-	"crypto/sha256"
 	"hash"
 	"io"
 	"sort"
@@ -18,8 +18,16 @@ type Reconstructor struct {
 	// read-only's
 	checksumToIndexes map[Sum224][]int
 	chunkHashes       []Sum224
+	top               *Sum224 // expected top checksum, nil without a Metadata
+	start             int     // chunks written before resuming
+	lengths           []int64 // expected chunk lengths, nil without a Metadata
+	longest           int64   // longest of lengths, that of parity chunks
+	mtop              bool    // top checksum is the Merkle root of chunkHashes
 
 	// r/w, mutex inside embed
+	erasure         *erasureState // nil without erasure coding
+	at              *atState      // nil unless writing to an io.WriterAt
+	ckpt            *checkpointer // nil without checkpoints
 	submittedChunks map[Sum224]struct{}
 
 	reconstructor
@@ -27,28 +35,59 @@ type Reconstructor struct {
 
 // Sum224 checks whether the streaming of chunks to the output stream is finished.
 // If it is ongoing, an error is returned.
-// Otherwise, the SHA-224 checksum of the stream is returned with no error.
+// Otherwise, the checksum of the stream is returned with no error, unless it
+// was never computed, see ReconstructAt. With a Metadata whose MerkleTop is
+// set, the Merkle root of the chunk checksums every chunk was checked against
+// is returned instead.
 func (rec *Reconstructor) Sum224() (Sum224, error) {
-	return rec.sum224()
+	sum, err := rec.sum224()
+	switch {
+	case err != nil:
+		return sum, err
+	case rec.mtop:
+		if _, err := rec.finErr(); err != nil {
+			return Sum224{}, err
+		}
+		return MerkleRoot(rec.alg, rec.chunkHashes), nil
+	case rec.at != nil && !rec.at.readable:
+		return Sum224{}, ErrTopChecksumUnchecked
+	}
+	return sum, nil
 }
 
 // Err returns any error encountered when writing to the output stream
 // if finished==true.
 // If finished==false, err is undefined.
+// Errors caused by a particular chunk are returned as a *ChunkError.
 func (rec *Reconstructor) Err() (finished bool, err error) {
 	return rec.finErr()
 }
 
 // Submit sinks chunk c (in any order) for the purpose of reconstructing the
 // original file.
-// All chunks will be reordered by rec.
+// All chunks will be reordered by rec. Compressed chunks are decompressed
+// and sealed chunks are opened, see SetKeyRing.
 // Submiting the same chunk more than once does nothing.
+// Errors concerning c are returned as a *ChunkError. If rec has already
+// stopped, the error it stopped with (see Err) is returned.
 func (rec *Reconstructor) Submit(c *C) error {
 	chunkHashRef := c.Sum224()
-
+	if c.alg != rec.alg {
+		return &ChunkError{Index: -1, Sum: chunkHashRef, Err: ErrHashMismatch}
+	}
 	idxs, ok := rec.checksumToIndexes[chunkHashRef]
-	if !ok {
-		return errNoChunkInMetadata
+	var parity []int
+	if rec.erasure != nil {
+		parity = rec.erasure.parityToPos[chunkHashRef]
+	}
+	if !ok && len(parity) == 0 {
+		return &ChunkError{Index: -1, Sum: chunkHashRef, Err: ErrNoChunkInMetadata}
+	}
+
+	// checked against its checksum like any other chunk by writeChunk
+	c, err := rec.plain(c, rec.maxLen(idxs))
+	if err != nil {
+		return &ChunkError{Index: -1, Sum: chunkHashRef, Err: err}
 	}
 
 	rec.mu.Lock()
@@ -60,72 +99,248 @@ func (rec *Reconstructor) Submit(c *C) error {
 
 	if rec.fin {
 		rec.mu.Unlock()
-		return errFinishedReconstructor
+		idx := -1
+		if len(idxs) > 0 {
+			idx = idxs[0]
+		}
+		return &ChunkError{Index: idx, Sum: chunkHashRef, Err: ErrFinishedReconstructor}
 	}
 
 	rec.submittedChunks[chunkHashRef] = struct{}{}
 
-	var ics []*indexedC
-	for _, v := range idxs {
-		ics = append(ics, &indexedC{C{c.b, c.h224}, v})
+	first, err := rec.queue(c, idxs, parity)
+	if err == nil && rec.at != nil {
+		err = rec.writeAt()
+		rec.mu.Unlock()
+		return err
 	}
-	rec.sorter = append(rec.sorter, ics...)
-	sort.Sort(rec.sorter)
 
 	rec.mu.Unlock()
 
-	rec.lastReceivedIndex <- idxs[0]
+	if err != nil {
+		return &ChunkError{Index: -1, Sum: chunkHashRef, Err: err}
+	}
+	if first < 0 {
+		return nil
+	}
+	return rec.notify(first)
+}
+
+// assume external lock
+// queue adds c to the sorter under every index in idxs, along with any chunk
+// which can be rebuilt from parity as a result. parity lists the positions of
+// c among the parity chunks, if it is one.
+// The lowest queued index is returned, or -1 if nothing was queued.
+func (rec *Reconstructor) queue(c *C, idxs, parity []int) (int, error) {
+	first := -1
+	push := func(c *C, idxs []int) {
+		h := &held{c.b, "", 0}
+		for _, v := range idxs {
+			if rec.written(v) {
+				continue
+			}
+			rec.buffer(&indexedC{*c, v, h})
+			if first < 0 || v < first {
+				first = v
+			}
+		}
+	}
+	defer func() {
+		sort.Sort(rec.sorter)
+		rec.spillOver()
+	}()
+
+	push(c, idxs)
+	if rec.erasure == nil {
+		return first, nil
+	}
 
-	if idxs[len(idxs)-1]+1 == len(rec.chunkHashes) {
-		close(rec.lastReceivedIndex)
+	var stripes []int
+	for _, v := range idxs {
+		stripes = append(stripes, rec.erasure.addData(c.b, v))
+	}
+	for _, v := range parity {
+		stripes = append(stripes, rec.erasure.addParity(c.b, v))
 	}
 
-	return nil
+	for len(stripes) > 0 {
+		rebuilt, err := rec.erasure.rebuild(stripes[0])
+		if err != nil {
+			return first, err
+		}
+		stripes = stripes[1:]
+
+		for idx, b := range rebuilt {
+			sum := rec.chunkHashes[idx]
+			if _, ok := rec.submittedChunks[sum]; ok {
+				continue
+			}
+			rec.submittedChunks[sum] = struct{}{}
+
+			// checked against sum like any other chunk by writeChunk
+			rc, err := NewChunkHash(bytes.NewReader(b), rec.alg)
+			if err != nil {
+				return first, err
+			}
+			push(rc, rec.checksumToIndexes[sum])
+			for _, v := range rec.checksumToIndexes[sum] {
+				stripes = append(stripes, rec.erasure.addData(b, v))
+			}
+		}
+	}
+	return first, nil
+}
+
+// assume external lock
+// written reports whether chunk i was written before a resumed reconstruction
+// started, or, in ReconstructAt mode, since.
+func (rec *Reconstructor) written(i int) bool {
+	return i < rec.start || (rec.at != nil && rec.at.isSet(i))
+}
+
+// SetKeyRing makes rec open sealed chunks with kr. It must be called before
+// submitting sealed chunks, which are rejected otherwise.
+func (rec *Reconstructor) SetKeyRing(kr *KeyRing) {
+	rec.mu.Lock()
+	rec.keys = kr
+	rec.mu.Unlock()
+}
+
+// SetSpill caps the memory taken by chunks submitted ahead of their turn to
+// maxMem bytes. Beyond that, the chunks furthest from being written are
+// spilled to files in a temporary directory created in dir (os.TempDir if
+// empty), and read back when their turn comes. The chunk closest to being
+// written always stays in memory. A chunk found at several indexes is held,
+// counted and spilled once for all of them. The directory is removed once
+// rec stops. A maxMem of 0, the default, keeps every chunk in memory.
+// The chunks of incomplete erasure coding stripes are always kept in memory.
+// It fails if maxMem is negative or rec has stopped.
+func (rec *Reconstructor) SetSpill(dir string, maxMem int64) error {
+	return rec.setSpill(dir, maxMem)
+}
+
+// BufferStats reports on the chunks rec holds until their turn to be written
+// comes.
+func (rec *Reconstructor) BufferStats() BufferStats {
+	return rec.bufferStats()
+}
+
+// Done returns a channel which is closed once rec has stopped writing to the
+// output stream and closed it, with or without error.
+func (rec *Reconstructor) Done() <-chan struct{} {
+	return rec.done
 }
 
 // Reconstruct returns a Reconstructor object based on the info in m.
 // Every chunk sunk (in any order) into the returned Reconstructor will be
 // written to w in order.
+// Every chunk is verified against its expected checksum right before being
+// written. A corrupt chunk is never written and stops the reconstruction,
+// with Err reporting a *ChunkError holding the chunk index along with the
+// expected and actual checksums.
 // The returned Reconstructor is nil if chunkHashes has 0 length.
 func Reconstruct(wc io.WriteCloser, chunkHashes []Sum224, timeout time.Duration) *Reconstructor {
-	if len(chunkHashes) < 1 {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	return reconstruct(ctx, cancel, wc, &Metadata{ChunkChecksums: chunkHashes}, false)
+}
+
+// ReconstructContext is like Reconstruct but runs until ctx is done instead of
+// until a timeout elapses.
+func ReconstructContext(ctx context.Context, wc io.WriteCloser, chunkHashes []Sum224) *Reconstructor {
+	ctx, cancel := context.WithCancel(ctx)
+	return reconstruct(ctx, cancel, wc, &Metadata{ChunkChecksums: chunkHashes}, false)
+}
+
+// ReconstructMetadata is like Reconstruct but takes the chunk checksums and
+// the hash algorithm from m. Only chunks created with m.Hash are accepted.
+// Once every chunk has been written, the checksum of the output stream is
+// compared with m.TopChecksum, and Err reports a top checksum error on
+// mismatch. A Merkle top checksum (see Metadata.MerkleTop) needs no such
+// check: it covers the chunk checksums every chunk is checked against.
+// If m has erasure coding parameters, parity chunks can be submitted too, and
+// the missing chunks of a stripe are rebuilt as soon as enough of its chunks
+// have been submitted. Until then, the stripe's chunks are kept in memory.
+// The returned Reconstructor is nil if m has no chunks or is inconsistent.
+func ReconstructMetadata(wc io.WriteCloser, m *Metadata, timeout time.Duration) *Reconstructor {
+	if m.validate() != nil {
 		return nil
 	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	return reconstruct(ctx, cancel, wc, m, true)
+}
 
-	rec := &Reconstructor{
-		make(map[Sum224][]int),
-		chunkHashes,
-		make(map[Sum224]struct{}),
-		reconstructor{
-			make(chan int),
-			sync.Mutex{},
-			sha256.New224(),
-			[]*indexedC{},
-			false,
-			nil,
-		},
+// ReconstructMetadataContext is like ReconstructMetadata but runs until ctx is
+// done instead of until a timeout elapses.
+func ReconstructMetadataContext(ctx context.Context, wc io.WriteCloser, m *Metadata) *Reconstructor {
+	if m.validate() != nil {
+		return nil
 	}
+	ctx, cancel := context.WithCancel(ctx)
+	return reconstruct(ctx, cancel, wc, m, true)
+}
 
-	for i, v := range chunkHashes {
-		rec.checksumToIndexes[v] = append(rec.checksumToIndexes[v], i)
+// reconstruct runs until every chunk of m has been written or ctx is done,
+// then calls cancel. If checkTop is true, m.TopChecksum is checked against the
+// checksum of the output stream.
+func reconstruct(ctx context.Context, cancel context.CancelFunc,
+	wc io.WriteCloser, m *Metadata, checkTop bool) *Reconstructor {
+
+	return resumeReconstruct(ctx, cancel, wc, m, checkTop, 0, nil)
+}
+
+// resumeReconstruct is like reconstruct but starts with chunk start, the ones
+// before it having already been written, and h holding the checksum of their
+// data. h may be nil if start is 0.
+func resumeReconstruct(ctx context.Context, cancel context.CancelFunc,
+	wc io.WriteCloser, m *Metadata, checkTop bool, start int, h hash.Hash) *Reconstructor {
+
+	rec := newReconstructor(m)
+	if rec == nil {
+		cancel()
+		return nil
 	}
-	for _, v := range rec.checksumToIndexes {
-		if len(v) > 1 {
-			sort.Ints(v)
-		}
+	if checkTop {
+		rec.top = &m.TopChecksum
 	}
-
-	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	if h != nil {
+		rec.h224 = h
+	}
+	rec.start = start
+	rec.markWritten()
 
 	go func() {
 		bw := bufio.NewWriterSize(wc, writeBufferSize)
+		nextIndex := start
+		var written int64 // absolute output offset
+		if start > 0 {
+			written = m.ChunkOffsets[start-1] + m.ChunkLengths[start-1]
+		}
 		defer func() {
-			bw.Flush()
+			if bw.Flush() == nil {
+				rec.mu.Lock()
+				rec.closeCheckpoint(wc, nextIndex, written)
+				rec.mu.Unlock()
+			}
 			wc.Close()
 			cancel()
+			rec.mu.Lock()
+			rec.removeSpill()
+			rec.mu.Unlock()
+			close(rec.done)
 		}()
 
-		nextIndex := 0
+		finish := func() {
+			if checkTop && !m.MerkleTop && !m.TopChecksum.EqB(rec.h224.Sum(nil)) {
+				rec.doneWith(ErrTopChecksum)
+			} else {
+				rec.doneWith(nil)
+			}
+		}
+		if nextIndex == len(rec.chunkHashes) {
+			finish()
+			return
+		}
+
 		for {
 
 			select {
@@ -135,16 +350,11 @@ func Reconstruct(wc io.WriteCloser, chunkHashes []Sum224, timeout time.Duration)
 				return
 
 			case i := <-rec.lastReceivedIndex:
-				if nextIndex == len(rec.chunkHashes) {
-					rec.doneWith(nil)
-					return
-				}
-
 				if nextIndex == i {
 
 					rec.mu.Lock()
 					for len(rec.sorter) > 0 && nextIndex == rec.sorter[len(rec.sorter)-1].idx {
-						err := rec.writeChunk(bw)
+						n, err := rec.writeChunk(bw, rec.chunkHashes)
 						if err != nil {
 							rec.err = err
 							rec.fin = true
@@ -152,9 +362,27 @@ func Reconstruct(wc io.WriteCloser, chunkHashes []Sum224, timeout time.Duration)
 							return
 						}
 						nextIndex++
+						written += int64(n)
+					}
+					if rec.ckpt != nil && rec.ckpt.due() && nextIndex < len(rec.chunkHashes) {
+						err := bw.Flush()
+						if err == nil {
+							err = rec.saveCheckpoint(wc, nextIndex, written)
+						}
+						if err != nil {
+							rec.err = err
+							rec.fin = true
+							rec.mu.Unlock()
+							return
+						}
 					}
 					rec.mu.Unlock()
 				}
+
+				if nextIndex == len(rec.chunkHashes) {
+					finish()
+					return
+				}
 			}
 		}
 	}()
@@ -162,6 +390,91 @@ func Reconstruct(wc io.WriteCloser, chunkHashes []Sum224, timeout time.Duration)
 	return rec
 }
 
+// markWritten marks the chunks written before a resumed reconstruction started
+// as submitted, so that submitting them again does nothing.
+func (rec *Reconstructor) markWritten() {
+	for sum, idxs := range rec.checksumToIndexes {
+		done := true
+		for _, v := range idxs {
+			done = done && rec.written(v)
+		}
+		if done {
+			rec.submittedChunks[sum] = struct{}{}
+		}
+	}
+}
+
+// newReconstructor returns a Reconstructor of the chunks of m, without any
+// goroutine writing them. It returns nil if m has no chunks or inconsistent
+// erasure coding parameters.
+func newReconstructor(m *Metadata) *Reconstructor {
+	chunkHashes := m.ChunkChecksums
+	if len(chunkHashes) < 1 {
+		return nil
+	}
+
+	rec := &Reconstructor{
+		make(map[Sum224][]int),
+		chunkHashes,
+		nil,
+		0,
+		m.ChunkLengths,
+		0,
+		m.MerkleTop,
+		nil,
+		nil,
+		nil,
+		make(map[Sum224]struct{}),
+		reconstructor{
+			make(chan int),
+			make(chan struct{}),
+			sync.Mutex{},
+			m.Hash,
+			m.Hash.New(),
+			[]*indexedC{},
+			false,
+			nil,
+			nil,
+			spiller{},
+		},
+	}
+	if m.Erasure != nil {
+		es, err := newErasureState(m)
+		if err != nil {
+			return nil
+		}
+		rec.erasure = es
+	}
+
+	for i, v := range chunkHashes {
+		rec.checksumToIndexes[v] = append(rec.checksumToIndexes[v], i)
+	}
+	for _, v := range m.ChunkLengths {
+		if v > rec.longest {
+			rec.longest = v
+		}
+	}
+	for _, v := range rec.checksumToIndexes {
+		if len(v) > 1 {
+			sort.Ints(v)
+		}
+	}
+	return rec
+}
+
+// maxLen returns the length of the chunks at idxs once decompressed, that of
+// the longest chunk for parity chunks, or DefaultMaxChunkSize if it is not
+// known.
+func (rec *Reconstructor) maxLen(idxs []int) int64 {
+	switch {
+	case rec.lengths == nil:
+		return DefaultMaxChunkSize
+	case len(idxs) > 0:
+		return rec.lengths[idxs[0]]
+	}
+	return rec.longest
+}
+
 // assume external lock
 func pop(s byReverseIndex) (*indexedC, byReverseIndex) {
 	if len(s) == 0 {
@@ -174,11 +487,48 @@ func pop(s byReverseIndex) (*indexedC, byReverseIndex) {
 
 type reconstructor struct {
 	lastReceivedIndex chan int
+	done              chan struct{} // closed when the writing goroutine exits
 	mu                sync.Mutex
+	alg               Hash
 	h224              hash.Hash
 	sorter            byReverseIndex
 	fin               bool
 	err               error
+	keys              *KeyRing
+	spill             spiller
+}
+
+// plain returns the original data of c, opening it with rec.keys if it is
+// sealed and decompressing it, up to max bytes, if it is compressed. The data
+// is not checked against the checksum of c.
+func (rec *reconstructor) plain(c *C, max int64) (*C, error) {
+	if c.sealed {
+		rec.mu.Lock()
+		kr := rec.keys
+		rec.mu.Unlock()
+		if kr == nil {
+			return nil, ErrChunkSealed
+		}
+		var err error
+		if c, err = kr.Open(c); err != nil {
+			return nil, err
+		}
+	}
+	return c.decompress(max)
+}
+
+// notify wakes the writing goroutine up. If the goroutine has already exited,
+// the error it exited with is returned.
+// Note that the goroutine may exit successfully after writing the chunk
+// being notified about before receiving idx.
+func (rec *reconstructor) notify(idx int) error {
+	select {
+	case rec.lastReceivedIndex <- idx:
+		return nil
+	case <-rec.done:
+		_, err := rec.finErr()
+		return err
+	}
 }
 
 func (rec *reconstructor) doneWith(err error) {
@@ -201,7 +551,7 @@ func (rec *reconstructor) sum224() (Sum224, error) {
 	rec.mu.Lock()
 	defer rec.mu.Unlock()
 	if !rec.fin {
-		return Sum224{}, errStreamStillRunning
+		return Sum224{}, ErrStreamStillRunning
 	}
 	var res Sum224
 	copy(res[:], rec.h224.Sum(nil))
@@ -209,20 +559,60 @@ func (rec *reconstructor) sum224() (Sum224, error) {
 }
 
 // assume external lock
-func (rec *reconstructor) writeChunk(w io.Writer) error {
-	var c *indexedC
-	c, rec.sorter = pop(rec.sorter)
-	h := sha256.New224()
-	mw := io.MultiWriter(w, h, rec.h224)
-	_, err := io.Copy(mw, c.Reader())
+// writeChunk pops the next chunk and writes it to w if its content matches
+// expected[idx], or its own checksum if expected is nil. It returns the length
+// of the chunk.
+func (rec *reconstructor) writeChunk(w io.Writer, expected []Sum224) (int, error) {
+	c, want, err := rec.popChunk(expected)
 	if err != nil {
-		return err
+		return 0, err
 	}
 
-	// hash check
-	if !c.IsHash(h.Sum(nil)) {
-		return err
+	mw := io.MultiWriter(w, rec.h224)
+	if _, err := mw.Write(c.b); err != nil {
+		return 0, &ChunkError{Index: c.idx, Sum: want, Err: err}
+	}
+	return len(c.b), nil
+}
+
+// assume external lock
+// popChunk pops the next chunk and checks its content against expected[idx],
+// or its own checksum if expected is nil. The checksum checked against is
+// returned along with the chunk.
+func (rec *reconstructor) popChunk(expected []Sum224) (*indexedC, Sum224, error) {
+	c, want, err := rec.takeChunk(expected)
+	if err == nil {
+		err = checkChunk(c, want)
 	}
+	return c, want, err
+}
 
+// assume external lock
+// takeChunk pops the next chunk, without checking it, along with
+// expected[idx], or its own checksum if expected is nil.
+func (rec *reconstructor) takeChunk(expected []Sum224) (*indexedC, Sum224, error) {
+	var c *indexedC
+	c, rec.sorter = pop(rec.sorter)
+	var want Sum224
+	if expected != nil {
+		want = expected[c.idx]
+	} else {
+		want = c.Sum224()
+	}
+	if err := rec.unbuffer(c); err != nil {
+		return nil, want, &ChunkError{Index: c.idx, Sum: want, Err: err}
+	}
+	return c, want, nil
+}
+
+// checkChunk checks the content of c, taken from the sorter, against want.
+func checkChunk(c *indexedC, want Sum224) error {
+	var got Sum224
+	h := c.alg.New()
+	h.Write(c.b)
+	copy(got[:], h.Sum(nil))
+	if !want.Eq(got) {
+		return &ChunkError{Index: c.idx, Sum: want, Err: ErrChunkChecksum, Actual: got}
+	}
 	return nil
 }
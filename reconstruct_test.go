@@ -2,6 +2,9 @@ package chunk
 
 import (
 	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
 	"os"
 	"sync"
 	"testing"
@@ -103,6 +106,123 @@ func TestReconstructRepeatedData(t *testing.T) {
 		out.String())
 }
 
+func TestReconstructContext(t *testing.T) {
+	c1sum, err := NewSum224("d0b4d664a97100ce9fd81a8ddd0051b80dfdbdcefb0d98a56231909d")
+	assert.Nil(t, err)
+	c2sum, err := NewSum224("0a159b778546794379682eef59eb6cec6da039dc9222e4c65660f98e")
+	assert.Nil(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	out := noopCloseWriteCloser{bytes.NewBuffer(nil), &sync.Mutex{}}
+	rec := ReconstructContext(ctx, out, []Sum224{c1sum, c2sum})
+
+	assert.Nil(t, rec.Submit(cFromFile(t, "testdata/chunk2")))
+	cancel()
+	<-rec.Done()
+
+	fin, err := rec.Err()
+	assert.True(t, fin)
+	assert.Equal(t, context.Canceled, err)
+
+	// does not block once the context is done
+	err = rec.Submit(cFromFile(t, "testdata/chunk1"))
+	assert.True(t, errors.Is(err, ErrFinishedReconstructor))
+
+	br := BlindReconstructContext(ctx, out)
+	<-br.done
+	assert.NotNil(t, br.Submit(cFromFile(t, "testdata/chunk1"), 0))
+}
+
+func TestReconstructCorruptChunk(t *testing.T) {
+	c1 := cFromFile(t, "testdata/chunk1")
+	c2 := cFromFile(t, "testdata/chunk2")
+
+	// content no longer matching the checksum recorded at creation
+	bad := &C{append([]byte(nil), c2.b...), c2.h224, c2.alg, nil, false}
+	bad.b[0] ^= 0xff
+
+	out := noopCloseWriteCloser{bytes.NewBuffer(nil), &sync.Mutex{}}
+	rec := Reconstruct(out, []Sum224{c1.Sum224(), c2.Sum224()}, 1*time.Second)
+	assert.Nil(t, rec.Submit(bad))
+	assert.Nil(t, rec.Submit(c1))
+	<-rec.Done()
+
+	fin, err := rec.Err()
+	assert.True(t, fin)
+	assert.True(t, errors.Is(err, ErrChunkChecksum))
+	var ce *ChunkError
+	assert.True(t, errors.As(err, &ce))
+	assert.Equal(t, 1, ce.Index)
+	assert.Equal(t, c2.Sum224(), ce.Sum)
+	assert.Equal(t, sumOf(t, bad.b), ce.Actual)
+	assert.Equal(t, "chunk checksum error: chunk 1 "+
+		"(0a159b778546794379682eef59eb6cec6da039dc9222e4c65660f98e): got "+
+		sumOf(t, bad.b).String(), err.Error())
+
+	// the corrupt chunk never reaches the output
+	c1data, _ := ioutil.ReadFile("testdata/chunk1")
+	assert.Equal(t, string(c1data), out.String())
+}
+
+func TestReconstructTopChecksum(t *testing.T) {
+	m := metadataFromFile(t, "testdata/all", 30)
+	m.TopChecksum = m.ChunkChecksums[0]
+
+	out := noopCloseWriteCloser{bytes.NewBuffer(nil), &sync.Mutex{}}
+	rec := ReconstructMetadata(out, m, 1*time.Second)
+	for _, p := range []string{"testdata/chunk1", "testdata/chunk2", "testdata/chunk3",
+		"testdata/chunk4", "testdata/chunk5"} {
+		rec.Submit(cFromFile(t, p)) // may already report the mismatch
+	}
+	<-rec.Done()
+
+	_, err := rec.Err()
+	assert.Equal(t, ErrTopChecksum, err)
+}
+
+func TestReconstructMerkleTop(t *testing.T) {
+	data, _ := ioutil.ReadFile("testdata/all")
+	sp := &Splitter{Width: 30, MerkleTop: true, Timeout: time.Second}
+	s := sp.Split(ioutil.NopCloser(bytes.NewReader(data)))
+	var chunks []*C
+	for c := s.Next(); c != nil; c = s.Next() {
+		chunks = append(chunks, c)
+	}
+	m, err := s.Metadata()
+	assert.Nil(t, err)
+
+	out := noopCloseWriteCloser{bytes.NewBuffer(nil), &sync.Mutex{}}
+	rec := ReconstructMetadata(out, m, 1*time.Second)
+	for i := len(chunks) - 1; i >= 0; i-- {
+		rec.Submit(chunks[i])
+	}
+	<-rec.Done()
+	_, err = rec.Err()
+	assert.Nil(t, err)
+	sum, err := rec.Sum224()
+	assert.Nil(t, err)
+	assert.Equal(t, m.TopChecksum, sum)
+	assert.Equal(t, string(data), out.String())
+
+	// nothing is read back from an io.WriterAt
+	w := &memWriterAt{}
+	rec = ReconstructAt(context.Background(), w, m)
+	for _, c := range chunks {
+		assert.Nil(t, rec.Submit(c))
+	}
+	<-rec.Done()
+	sum, err = rec.Sum224()
+	assert.Nil(t, err)
+	assert.Equal(t, m.TopChecksum, sum)
+	assert.Equal(t, data, w.b)
+}
+
+func sumOf(t *testing.T, b []byte) Sum224 {
+	c, err := NewChunk(bytes.NewReader(b))
+	assert.Nil(t, err)
+	return c.Sum224()
+}
+
 func cFromFile(t *testing.T, path string) *C {
 	f, err := os.Open(path)
 	assert.Nil(t, err)
@@ -0,0 +1,297 @@
+package chunk
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"io"
+	"io/ioutil"
+	"sync"
+)
+
+// Sealed chunks hold an envelope around the AES-GCM ciphertext of their data:
+//
+//	mode uint8 | key ID length uint8 | key ID | nonce | ciphertext
+//
+// The chunk checksum and codec name are authenticated as additional data, so
+// that a sealed chunk cannot pass for another one, nor for the same data
+// compressed differently.
+const (
+	sealRandom     = 0 // the key is used as is, with a random nonce
+	sealConvergent = 1 // the key is derived from the chunk checksum, the nonce from the data sealed
+
+	sealNonceSize = 12
+
+	// longest envelope and GCM tag around a ciphertext
+	maxSealOverhead = 2 + 255 + sealNonceSize + 16
+)
+
+// KeyRing holds AES keys by ID, for Sealers to seal chunks with and for
+// readers to open them.
+// It is thread safe.
+type KeyRing struct {
+	mu   sync.RWMutex
+	keys map[string][]byte
+}
+
+// NewKeyRing returns an empty KeyRing.
+func NewKeyRing() *KeyRing {
+	return &KeyRing{keys: make(map[string][]byte)}
+}
+
+// Add registers key under id. key must be 16, 24 or 32 bytes long to select
+// AES-128, AES-192 or AES-256, and id at most 255 bytes long.
+// Adding a key under an ID already in use replaces it.
+func (kr *KeyRing) Add(id string, key []byte) error {
+	if len(id) > 255 {
+		return ErrInvalidArgs
+	}
+	if _, err := aes.NewCipher(key); err != nil {
+		return ErrInvalidArgs
+	}
+
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+	kr.keys[id] = append([]byte(nil), key...)
+	return nil
+}
+
+func (kr *KeyRing) key(id string) ([]byte, error) {
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+	key, ok := kr.keys[id]
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+	return key, nil
+}
+
+// Open returns a chunk holding the decrypted data of c, or c itself if it is
+// not sealed. The returned chunk is still compressed if c is.
+// An error is returned if the key c is sealed with is not in kr, or if the
+// data does not authenticate.
+func (kr *KeyRing) Open(c *C) (*C, error) {
+	if !c.sealed {
+		return c, nil
+	}
+
+	r := bytes.NewReader(c.b)
+	mode, err := r.ReadByte()
+	if err != nil {
+		return nil, ErrChunkSealed
+	}
+	l, err := r.ReadByte()
+	if err != nil {
+		return nil, ErrChunkSealed
+	}
+	id := make([]byte, l)
+	nonce := make([]byte, sealNonceSize)
+	if _, err := io.ReadFull(r, id); err != nil {
+		return nil, ErrChunkSealed
+	}
+	if _, err := io.ReadFull(r, nonce); err != nil {
+		return nil, ErrChunkSealed
+	}
+
+	key, err := kr.key(string(id))
+	if err != nil {
+		return nil, err
+	}
+	switch mode {
+	case sealRandom:
+	case sealConvergent:
+		key = convergentKey(key, c.Sum224())
+	default:
+		return nil, ErrChunkSealed
+	}
+
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	b, err := aead.Open(nil, nonce, c.b[len(c.b)-r.Len():], sealAD(c))
+	if err != nil {
+		return nil, ErrChunkSealed
+	}
+	return &C{b, c.h224, c.alg, c.codec, false}, nil
+}
+
+// check opens c and checks its data, decompressed up to max bytes if c is
+// compressed, against the checksum of c, which a sealed chunk otherwise
+// takes on trust.
+func (kr *KeyRing) check(c *C, max int64) error {
+	o, err := kr.Open(c)
+	if err != nil {
+		return err
+	}
+	d, err := o.decompress(max)
+	if err != nil {
+		return err
+	}
+	h := c.alg.New()
+	h.Write(d.b)
+	if !c.IsHash(h.Sum(nil)) {
+		return ErrChunkChecksum
+	}
+	return nil
+}
+
+// Fetcher returns a Fetcher getting chunks from f and opening them with kr.
+func (kr *KeyRing) Fetcher(f Fetcher) Fetcher {
+	return keyRingFetcher{kr, f}
+}
+
+type keyRingFetcher struct {
+	kr *KeyRing
+	f  Fetcher
+}
+
+func (kf keyRingFetcher) Get(sum Sum224) (*C, error) {
+	c, err := kf.f.Get(sum)
+	if err != nil {
+		return nil, err
+	}
+	return kf.kr.Open(c)
+}
+
+// Sealer seals chunks with AES-GCM using the key KeyID of Keys.
+type Sealer struct {
+	Keys  *KeyRing
+	KeyID string
+
+	// Convergent derives the key of every chunk from the key KeyID and the
+	// chunk checksum, and its nonce from that key and the data sealed, codec
+	// included, so that identical chunks sealed with the same key are
+	// identical and still dedupe, while different data is never sealed with
+	// the same key and nonce. This reveals which chunks are identical to
+	// anyone who can see them.
+	// Otherwise, the key is used as is with a random nonce.
+	Convergent bool
+}
+
+// Seal returns a chunk holding the data of c encrypted and authenticated with
+// AES-GCM, with the same checksum as c.
+// Compressed chunks are sealed as is, and stay compressed once opened.
+// If c is already sealed, c itself is returned.
+func (s *Sealer) Seal(c *C) (*C, error) {
+	if c.sealed {
+		return c, nil
+	}
+	if !s.valid() {
+		return nil, ErrUnknownKey
+	}
+	key, _ := s.Keys.key(s.KeyID)
+
+	ad := sealAD(c)
+	mode := byte(sealRandom)
+	var nonce []byte
+	if s.Convergent {
+		mode = sealConvergent
+		key = convergentKey(key, c.Sum224())
+		nonce = convergentNonce(key, ad, c.b)
+	} else {
+		nonce = make([]byte, sealNonceSize)
+		if _, err := rand.Read(nonce); err != nil {
+			return nil, err
+		}
+	}
+
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	b := make([]byte, 0, 2+len(s.KeyID)+sealNonceSize+len(c.b)+aead.Overhead())
+	b = append(b, mode, byte(len(s.KeyID)))
+	b = append(b, s.KeyID...)
+	b = append(b, nonce...)
+	b = aead.Seal(b, nonce, c.b, ad)
+	return &C{b, c.h224, c.alg, c.codec, true}, nil
+}
+
+func (s *Sealer) valid() bool {
+	if s.Keys == nil || len(s.KeyID) > 255 {
+		return false
+	}
+	_, err := s.Keys.key(s.KeyID)
+	return err == nil
+}
+
+// NewSealedChunk returns a chunk whose checksum is sum holding the sealed data
+// read from r, as found in the Reader of a sealed chunk. codec is the Codec
+// the data was compressed with before being sealed, if any.
+// The data can only be checked once opened.
+func NewSealedChunk(r io.Reader, alg Hash, codec Codec, sum Sum224) (*C, error) {
+	if !alg.Available() {
+		return nil, ErrUnknownHash
+	}
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return &C{b, knownSum(sum), alg, codec, true}, nil
+}
+
+// sealAD returns the additional data authenticated along with the data of c:
+// its checksum, then the length and name of its codec.
+func sealAD(c *C) []byte {
+	sum := c.Sum224()
+	var name string
+	if c.codec != nil {
+		name = c.codec.Name()
+	}
+	ad := make([]byte, 0, len(sum)+1+len(name))
+	ad = append(ad, sum[:]...)
+	ad = append(ad, byte(len(name)))
+	return append(ad, name...)
+}
+
+// convergentKey derives a key as long as key from key and sum.
+func convergentKey(key []byte, sum Sum224) []byte {
+	mac := hmac.New(sha512.New, key)
+	mac.Write(sum[:])
+	return mac.Sum(nil)[:len(key)]
+}
+
+// convergentNonce derives a nonce from key, the additional data ad and the
+// data b sealed with them, so that the same key never seals different data
+// under the same nonce.
+func convergentNonce(key, ad, b []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(ad)
+	mac.Write(b)
+	return mac.Sum(nil)[:sealNonceSize]
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// knownSum is a hash.Hash which only reports a checksum known in advance,
+// for chunks whose data cannot be checksummed yet.
+type knownSum Sum224
+
+func (k knownSum) Write(b []byte) (int, error) {
+	return len(b), nil
+}
+
+func (k knownSum) Sum(b []byte) []byte {
+	return append(b, k[:]...)
+}
+
+func (k knownSum) Reset() {}
+
+func (k knownSum) Size() int {
+	return sha256.Size224
+}
+
+func (k knownSum) BlockSize() int {
+	return sha256.BlockSize
+}
@@ -0,0 +1,151 @@
+package chunk
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testKeyRing(t *testing.T) *KeyRing {
+	kr := NewKeyRing()
+	assert.Nil(t, kr.Add("k1", bytes.Repeat([]byte{1}, 32)))
+	assert.Nil(t, kr.Add("k2", bytes.Repeat([]byte{2}, 16)))
+	assert.Equal(t, ErrInvalidArgs, kr.Add("k3", []byte("short")))
+	return kr
+}
+
+func TestSeal(t *testing.T) {
+	kr := testKeyRing(t)
+	c, err := NewChunk(strings.NewReader("attack at dawn"))
+	assert.Nil(t, err)
+
+	for _, convergent := range []bool{false, true} {
+		sl := &Sealer{kr, "k1", convergent}
+		s1, err := sl.Seal(c)
+		assert.Nil(t, err)
+		assert.True(t, s1.Sealed())
+		assert.Equal(t, c.Sum224(), s1.Sum224())
+		assert.False(t, bytes.Contains(s1.b, c.b))
+
+		s2, err := sl.Seal(c)
+		assert.Nil(t, err)
+		assert.Equal(t, convergent, bytes.Equal(s1.b, s2.b))
+
+		o, err := kr.Open(s1)
+		assert.Nil(t, err)
+		assert.False(t, o.Sealed())
+		assert.Equal(t, c.b, o.b)
+
+		// sealed chunks read back from their content
+		s3, err := NewSealedChunk(s1.Reader(), SHA224, nil, c.Sum224())
+		assert.Nil(t, err)
+		o, err = kr.Open(s3)
+		assert.Nil(t, err)
+		assert.Equal(t, c.b, o.b)
+
+		// tampered content
+		bad := append([]byte(nil), s1.b...)
+		bad[len(bad)-1] ^= 1
+		s3, _ = NewSealedChunk(bytes.NewReader(bad), SHA224, nil, c.Sum224())
+		_, err = kr.Open(s3)
+		assert.Equal(t, ErrChunkSealed, err)
+
+		// content passed off as another chunk
+		s3, _ = NewSealedChunk(s1.Reader(), SHA224, nil, Sum224{})
+		_, err = kr.Open(s3)
+		assert.Equal(t, ErrChunkSealed, err)
+
+		_, err = c.Decompress(int64(len(c.b)))
+		assert.Nil(t, err)
+		_, err = s1.Decompress(int64(len(c.b)))
+		assert.Equal(t, ErrChunkSealed, err)
+	}
+
+	// the same chunk compressed is sealed under another nonce, and cannot
+	// pass for uncompressed
+	c, err = NewChunk(strings.NewReader(strings.Repeat("attack at dawn ", 100)))
+	assert.Nil(t, err)
+	z, err := c.Compress(Gzip)
+	assert.Nil(t, err)
+	assert.NotEqual(t, c, z)
+	sl := &Sealer{kr, "k1", true}
+	s1, err := sl.Seal(c)
+	assert.Nil(t, err)
+	s2, err := sl.Seal(z)
+	assert.Nil(t, err)
+	nonce := func(s *C) []byte { return s.b[2+len("k1") : 2+len("k1")+sealNonceSize] }
+	assert.NotEqual(t, nonce(s1), nonce(s2))
+	s3, _ := NewSealedChunk(s2.Reader(), SHA224, nil, c.Sum224())
+	_, err = kr.Open(s3)
+	assert.Equal(t, ErrChunkSealed, err)
+	s3, _ = NewSealedChunk(s2.Reader(), SHA224, Gzip, c.Sum224())
+	o, err := kr.Open(s3)
+	assert.Nil(t, err)
+	assert.Equal(t, z.b, o.b)
+
+	_, err = (&Sealer{kr, "nope", false}).Seal(c)
+	assert.Equal(t, ErrUnknownKey, err)
+	s, err := (&Sealer{kr, "k2", false}).Seal(c)
+	assert.Nil(t, err)
+	_, err = NewKeyRing().Open(s)
+	assert.Equal(t, ErrUnknownKey, err)
+}
+
+func TestSplitStreamSealed(t *testing.T) {
+	kr := testKeyRing(t)
+	data := []byte(strings.Repeat("0123456789", 1000) + "tail")
+	sp := &Splitter{
+		Width:   1000,
+		Codec:   Gzip,
+		Sealer:  &Sealer{kr, "k1", true},
+		Timeout: 1 * time.Second,
+	}
+	s := sp.Split(ioutil.NopCloser(bytes.NewReader(data)))
+	assert.NotNil(t, s)
+
+	dir, err := ioutil.TempDir("", "chunkstore")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	fs, err := NewFileStore(dir, SHA224)
+	assert.Nil(t, err)
+
+	var chunks []*C
+	for c := s.Next(); c != nil; c = s.Next() {
+		assert.True(t, c.Sealed())
+		assert.Nil(t, fs.Put(c))
+		chunks = append(chunks, c)
+	}
+	m, err := s.Metadata()
+	assert.Nil(t, err)
+	assert.Equal(t, "gzip", m.ChunkCodecs[0])
+
+	// rejected without a key ring
+	out := noopCloseWriteCloser{bytes.NewBuffer(nil), &sync.Mutex{}}
+	rec := ReconstructMetadata(out, m, 1*time.Second)
+	assert.Equal(t, ErrChunkSealed, rec.Submit(chunks[0]).(*ChunkError).Err)
+
+	rec.SetKeyRing(kr)
+	for i := len(chunks) - 1; i >= 0; i-- {
+		rec.Submit(chunks[i])
+	}
+	<-rec.Done()
+	fin, err := rec.Err()
+	assert.True(t, fin)
+	assert.Nil(t, err)
+	assert.Equal(t, string(data), out.String())
+
+	// from the store, through a key ring
+	buf := bytes.NewBuffer(nil)
+	assert.Nil(t, ReconstructFrom(buf, m, kr.Fetcher(fs), 4, 0, 1*time.Second))
+	assert.Equal(t, string(data), buf.String())
+
+	// invalid sealer
+	sp.Sealer = &Sealer{kr, "nope", true}
+	assert.Nil(t, sp.Split(ioutil.NopCloser(bytes.NewReader(data))))
+}
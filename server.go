@@ -0,0 +1,288 @@
+package chunk
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// HTTP headers describing the content of a chunk when it is not raw data.
+const (
+	headerCodec  = "X-Chunk-Codec"  // name of the Codec the content is compressed with
+	headerSealed = "X-Chunk-Sealed" // "1" if the content is sealed
+)
+
+// Default limits of a Handler. DefaultMaxChunkSize also bounds decompressed
+// chunks whose length is not known otherwise, see HTTPFetcher,
+// BlindReconstructor, and Reconstructor without a Metadata.
+const (
+	DefaultMaxChunkSize = 64 * 1024 * 1024 // 64MB
+	DefaultMaxHave      = 10000
+)
+
+// Handler is an http.Handler serving the chunks of a Store, and manifests.
+//
+//	GET, HEAD /chunks/<hex sum>   the chunk content, as stored
+//	PUT       /chunks/<hex sum>   stores the request body as a chunk
+//	POST      /have               body: JSON array of hex sums, response: JSON
+//	                              array of those the Store has
+//	GET       /manifests/<name>   a manifest added with AddManifest, binary
+//	                              encoded if application/octet-stream is
+//	                              accepted, JSON otherwise
+//
+// Compressed and sealed chunks carry the X-Chunk-Codec and X-Chunk-Sealed
+// headers, both in responses and PUT requests. PUT requests are checked
+// against the sum in the URL. Sealed chunks can only be checked once opened,
+// and are refused unless the Handler has the keys to open them, see
+// SetKeyRing. Chunks over the size limit, compressed or not, are refused with
+// 413 Request Entity Too Large.
+// It is thread safe.
+type Handler struct {
+	// read only
+	store        Store
+	alg          Hash
+	maxChunkSize int64
+	maxHave      int
+
+	// r/w
+	mu        sync.RWMutex
+	manifests map[string]*Metadata
+	keys      *KeyRing
+}
+
+// NewHandler returns a Handler serving the chunks of s, checksummed with alg.
+// PUT requests are limited to maxChunkSize bytes, and have queries to maxHave
+// sums. Limits below 1 select DefaultMaxChunkSize and DefaultMaxHave.
+// The returned Handler is nil if s==nil or alg is unknown.
+func NewHandler(s Store, alg Hash, maxChunkSize int64, maxHave int) *Handler {
+	if s == nil || !alg.Available() {
+		return nil
+	}
+	if maxChunkSize < 1 {
+		maxChunkSize = DefaultMaxChunkSize
+	}
+	if maxHave < 1 {
+		maxHave = DefaultMaxHave
+	}
+	return &Handler{
+		s,
+		alg,
+		maxChunkSize,
+		maxHave,
+		sync.RWMutex{},
+		make(map[string]*Metadata),
+		nil,
+	}
+}
+
+// SetKeyRing makes h accept sealed chunks in PUT requests, provided they can
+// be opened with kr and match the sum in the URL. Without a KeyRing, the
+// default, sealed chunks are refused with 403 Forbidden since nothing would
+// keep them from claiming the sum of another chunk.
+func (h *Handler) SetKeyRing(kr *KeyRing) {
+	h.mu.Lock()
+	h.keys = kr
+	h.mu.Unlock()
+}
+
+// AddManifest makes m available under /manifests/name, replacing any
+// manifest previously added under name.
+func (h *Handler) AddManifest(name string, m *Metadata) error {
+	if name == "" || strings.Contains(name, "/") {
+		return ErrInvalidArgs
+	}
+	if err := m.validate(); err != nil {
+		return err
+	}
+	h.mu.Lock()
+	h.manifests[name] = m
+	h.mu.Unlock()
+	return nil
+}
+
+// ServeHTTP implements http.Handler.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case strings.HasPrefix(r.URL.Path, "/chunks/"):
+		h.serveChunk(w, r, strings.TrimPrefix(r.URL.Path, "/chunks/"))
+	case r.URL.Path == "/have":
+		h.serveHave(w, r)
+	case strings.HasPrefix(r.URL.Path, "/manifests/"):
+		h.serveManifest(w, r, strings.TrimPrefix(r.URL.Path, "/manifests/"))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (h *Handler) serveChunk(w http.ResponseWriter, r *http.Request, name string) {
+	sum, err := NewSum224(name)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		c, err := h.store.Get(sum)
+		if err == ErrChunkNotFound {
+			http.NotFound(w, r)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		hd := w.Header()
+		hd.Set("Content-Type", "application/octet-stream")
+		hd.Set("ETag", `"`+name+`"`)
+		hd.Set("Cache-Control", "public, max-age=31536000, immutable")
+		if c.codec != nil {
+			hd.Set(headerCodec, c.codec.Name())
+		}
+		if c.sealed {
+			hd.Set(headerSealed, "1")
+		}
+		// handles HEAD, conditional and range requests; chunks are immutable
+		// and have no modification time, conditional requests rely on ETag
+		http.ServeContent(w, r, "", time.Time{}, c.Reader())
+
+	case http.MethodPut:
+		var codec Codec
+		if v := r.Header.Get(headerCodec); v != "" {
+			if codec, err = CodecByName(v); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		body := http.MaxBytesReader(w, r.Body, h.maxChunkSize)
+
+		var c *C
+		if r.Header.Get(headerSealed) == "1" {
+			h.mu.RLock()
+			kr := h.keys
+			h.mu.RUnlock()
+			if kr == nil {
+				http.Error(w, ErrChunkSealed.Error(), http.StatusForbidden)
+				return
+			}
+			c, err = h.sealedChunk(body, kr, codec, sum)
+		} else {
+			c, err = NewChunkCodec(body, h.alg, codec, h.maxChunkSize)
+		}
+		var tooLarge *http.MaxBytesError
+		switch {
+		case err == ErrChunkTooLarge || errors.As(err, &tooLarge):
+			http.Error(w, ErrChunkTooLarge.Error(), http.StatusRequestEntityTooLarge)
+			return
+		case err == ErrChunkChecksum || err == ErrChunkSealed || err == ErrUnknownKey:
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if !c.Sum224().Eq(sum) {
+			http.Error(w, ErrChunkChecksum.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		if err := h.store.Put(c); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+
+	default:
+		w.Header().Set("Allow", "GET, HEAD, PUT")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+}
+
+// sealedChunk reads a chunk sealed with a key of kr, and compressed with codec
+// if not nil, from r. It is opened to be checked against sum, and returned
+// sealed.
+func (h *Handler) sealedChunk(r io.Reader, kr *KeyRing, codec Codec, sum Sum224) (*C, error) {
+	c, err := NewSealedChunk(r, h.alg, codec, sum)
+	if err != nil {
+		return nil, err
+	}
+	if err := kr.check(c, h.maxChunkSize); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (h *Handler) serveHave(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	var sums []Sum224
+	// 2 quotes and a comma around every hex sum, and the brackets
+	body := http.MaxBytesReader(w, r.Body, int64(h.maxHave)*59+2)
+	if err := json.NewDecoder(body).Decode(&sums); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(sums) > h.maxHave {
+		http.Error(w, ErrInvalidArgs.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	have := []Sum224{}
+	for _, v := range sums {
+		ok, err := h.store.Has(v)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if ok {
+			have = append(have, v)
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(have)
+}
+
+func (h *Handler) serveManifest(w http.ResponseWriter, r *http.Request, name string) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	h.mu.RLock()
+	m, ok := h.manifests[name]
+	h.mu.RUnlock()
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	var b []byte
+	var err error
+	if strings.Contains(r.Header.Get("Accept"), "application/octet-stream") {
+		w.Header().Set("Content-Type", "application/octet-stream")
+		b, err = m.MarshalBinary()
+	} else {
+		w.Header().Set("Content-Type", "application/json")
+		b, err = json.Marshal(m)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Vary", "Accept")
+	w.Header().Set("Content-Length", strconv.Itoa(len(b)))
+	if r.Method == http.MethodHead {
+		return
+	}
+	w.Write(b)
+}
@@ -0,0 +1,189 @@
+package chunk
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestServer(t *testing.T) (*httptest.Server, *Handler, func()) {
+	dir, err := ioutil.TempDir("", "chunkstore")
+	assert.Nil(t, err)
+	fs, err := NewFileStore(dir, SHA224)
+	assert.Nil(t, err)
+	h := NewHandler(fs, SHA224, 1024, 2)
+	srv := httptest.NewServer(h)
+	return srv, h, func() {
+		srv.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func doRequest(t *testing.T, method, url string, body []byte, hdr map[string]string) (*http.Response, []byte) {
+	req, err := http.NewRequest(method, url, bytes.NewReader(body))
+	assert.Nil(t, err)
+	for k, v := range hdr {
+		req.Header.Set(k, v)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	assert.Nil(t, err)
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	assert.Nil(t, err)
+	return resp, b
+}
+
+func TestHandlerChunks(t *testing.T) {
+	srv, _, done := newTestServer(t)
+	defer done()
+
+	data := []byte(strings.Repeat("chunk server ", 50))
+	c, err := NewChunk(bytes.NewReader(data))
+	assert.Nil(t, err)
+	url := srv.URL + "/chunks/" + c.Sum224().String()
+
+	resp, _ := doRequest(t, "GET", url, nil, nil)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	// wrong content for the sum
+	resp, _ = doRequest(t, "PUT", url, data[1:], nil)
+	assert.Equal(t, http.StatusUnprocessableEntity, resp.StatusCode)
+
+	resp, _ = doRequest(t, "PUT", url, data, nil)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	resp, b := doRequest(t, "GET", url, nil, nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, data, b)
+	etag := resp.Header.Get("ETag")
+
+	resp, b = doRequest(t, "HEAD", url, nil, nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "650", resp.Header.Get("Content-Length"))
+	assert.Empty(t, b)
+
+	resp, _ = doRequest(t, "GET", url, nil, map[string]string{"If-None-Match": etag})
+	assert.Equal(t, http.StatusNotModified, resp.StatusCode)
+
+	// compressed chunks keep their codec
+	c2, _ := NewChunk(strings.NewReader(strings.Repeat("other ", 100)))
+	z2, _ := c2.Compress(Gzip)
+	url2 := srv.URL + "/chunks/" + c2.Sum224().String()
+	resp, _ = doRequest(t, "PUT", url2, z2.b, map[string]string{headerCodec: "gzip"})
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	resp, b = doRequest(t, "GET", url2, nil, nil)
+	assert.Equal(t, "gzip", resp.Header.Get(headerCodec))
+	assert.Equal(t, z2.b, b)
+
+	resp, _ = doRequest(t, "PUT", url2, z2.b, map[string]string{headerCodec: "lzma"})
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	// too large, compressed or not
+	big := bytes.Repeat([]byte{1}, 2000)
+	cb, _ := NewChunk(bytes.NewReader(big))
+	resp, _ = doRequest(t, "PUT", srv.URL+"/chunks/"+cb.Sum224().String(), big, nil)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, resp.StatusCode)
+	zb, _ := cb.Compress(Gzip)
+	assert.True(t, len(zb.b) < 1024)
+	resp, _ = doRequest(t, "PUT", srv.URL+"/chunks/"+cb.Sum224().String(), zb.b,
+		map[string]string{headerCodec: "gzip"})
+	assert.Equal(t, http.StatusRequestEntityTooLarge, resp.StatusCode)
+
+	resp, _ = doRequest(t, "GET", srv.URL+"/chunks/xyz", nil, nil)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	resp, _ = doRequest(t, "DELETE", url, nil, nil)
+	assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+
+	// have queries
+	q, _ := json.Marshal([]Sum224{c.Sum224(), cb.Sum224(), c2.Sum224()})
+	resp, _ = doRequest(t, "POST", srv.URL+"/have", q, nil)
+	assert.NotEqual(t, http.StatusOK, resp.StatusCode) // more than 2 sums
+
+	q, _ = json.Marshal([]Sum224{c.Sum224(), cb.Sum224()})
+	resp, b = doRequest(t, "POST", srv.URL+"/have", q, nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	var have []Sum224
+	assert.Nil(t, json.Unmarshal(b, &have))
+	assert.Equal(t, []Sum224{c.Sum224()}, have)
+}
+
+func TestHandlerSealed(t *testing.T) {
+	srv, h, done := newTestServer(t)
+	defer done()
+
+	kr := NewKeyRing()
+	assert.Nil(t, kr.Add("k", make([]byte, 16)))
+	sealer := &Sealer{Keys: kr, KeyID: "k"}
+	for i, codec := range []Codec{nil, Flate} {
+		c, _ := NewChunk(strings.NewReader(strings.Repeat("sealed ", 100+i)))
+		z := c
+		hdr := map[string]string{headerSealed: "1"}
+		if codec != nil {
+			z, _ = c.Compress(codec)
+			hdr[headerCodec] = codec.Name()
+		}
+		s, err := sealer.Seal(z)
+		assert.Nil(t, err)
+		url := srv.URL + "/chunks/" + c.Sum224().String()
+
+		// unverifiable without keys
+		h.SetKeyRing(nil)
+		resp, _ := doRequest(t, "PUT", url, s.b, hdr)
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+		// garbage, another chunk, or other content sealed under the sum
+		h.SetKeyRing(kr)
+		resp, _ = doRequest(t, "PUT", url, []byte("garbage"), hdr)
+		assert.Equal(t, http.StatusUnprocessableEntity, resp.StatusCode)
+		other, _ := NewChunk(strings.NewReader("other"))
+		so, _ := sealer.Seal(other)
+		resp, _ = doRequest(t, "PUT", url, so.b, map[string]string{headerSealed: "1"})
+		assert.Equal(t, http.StatusUnprocessableEntity, resp.StatusCode)
+		forged, _ := sealer.Seal(&C{[]byte("forged"), c.h224, c.alg, nil, false})
+		resp, _ = doRequest(t, "PUT", url, forged.b, map[string]string{headerSealed: "1"})
+		assert.Equal(t, http.StatusUnprocessableEntity, resp.StatusCode)
+		resp, _ = doRequest(t, "GET", url, nil, nil)
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+		resp, _ = doRequest(t, "PUT", url, s.b, hdr)
+		assert.Equal(t, http.StatusCreated, resp.StatusCode)
+		resp, b := doRequest(t, "GET", url, nil, nil)
+		assert.Equal(t, "1", resp.Header.Get(headerSealed))
+		assert.Equal(t, s.b, b)
+	}
+}
+
+func TestHandlerManifests(t *testing.T) {
+	srv, h, done := newTestServer(t)
+	defer done()
+
+	m := metadataFromFile(t, "testdata/all", 30)
+	assert.Nil(t, h.AddManifest("all", m))
+	assert.Equal(t, ErrInvalidArgs, h.AddManifest("a/b", m))
+
+	resp, b := doRequest(t, "GET", srv.URL+"/manifests/all", nil, nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+	var m2 Metadata
+	assert.Nil(t, json.Unmarshal(b, &m2))
+	assert.Equal(t, *m, m2)
+
+	resp, b = doRequest(t, "GET", srv.URL+"/manifests/all", nil,
+		map[string]string{"Accept": "application/octet-stream"})
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	var m3 Metadata
+	assert.Nil(t, m3.UnmarshalBinary(b))
+	assert.Equal(t, *m, m3)
+
+	resp, _ = doRequest(t, "GET", srv.URL+"/manifests/none", nil, nil)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	resp, _ = doRequest(t, "GET", srv.URL+"/elsewhere", nil, nil)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
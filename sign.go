@@ -0,0 +1,163 @@
+package chunk
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/binary"
+	"io"
+	"time"
+)
+
+// signedMagic prefixes every binary encoded SignedManifest.
+var signedMagic = [4]byte{'C', 'H', 'N', 'S'}
+
+// signedVersion is the version of the SignedManifest binary format.
+const signedVersion = 1
+
+// signContext separates manifest signatures from any other use of the keys.
+const signContext = "chunk signed manifest v1\x00"
+
+// SignedManifest is a binary encoded Metadata signed with Ed25519, along with
+// the identity of its signer and the time it was signed at.
+type SignedManifest struct {
+	Manifest  []byte    `json:"manifest"` // see Metadata.MarshalBinary
+	Signer    string    `json:"signer"`
+	Timestamp time.Time `json:"timestamp"`
+	Signature []byte    `json:"signature"`
+}
+
+// TrustedKeys maps signer identities to their Ed25519 public keys.
+type TrustedKeys map[string]ed25519.PublicKey
+
+// Sign returns m signed with key on behalf of signer, at the current time.
+// signer must be at most 255 bytes long.
+func Sign(m *Metadata, signer string, key ed25519.PrivateKey) (*SignedManifest, error) {
+	if len(signer) > 255 || len(key) != ed25519.PrivateKeySize {
+		return nil, ErrInvalidArgs
+	}
+	b, err := m.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+
+	sm := &SignedManifest{
+		b,
+		signer,
+		time.Unix(0, time.Now().UnixNano()).UTC(),
+		nil,
+	}
+	sm.Signature = ed25519.Sign(key, sm.message())
+	return sm, nil
+}
+
+// Verify checks that sm has been signed by its signer with the key trusted
+// holds for it, and returns the Metadata it carries.
+// Nothing in the manifest should be relied upon if Verify fails.
+func (sm *SignedManifest) Verify(trusted TrustedKeys) (*Metadata, error) {
+	key, ok := trusted[sm.Signer]
+	if !ok || len(key) != ed25519.PublicKeySize {
+		return nil, ErrUntrustedSigner
+	}
+	if len(sm.Signer) > 255 || !ed25519.Verify(key, sm.message(), sm.Signature) {
+		return nil, ErrBadSignature
+	}
+
+	m := &Metadata{}
+	if err := m.UnmarshalBinary(sm.Manifest); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// message returns what is signed: the signer, the timestamp and the manifest.
+func (sm *SignedManifest) message() []byte {
+	buf := bytes.NewBuffer(nil)
+	buf.WriteString(signContext)
+	buf.WriteByte(byte(len(sm.Signer)))
+	buf.WriteString(sm.Signer)
+	binary.Write(buf, binary.BigEndian, sm.Timestamp.UnixNano())
+	buf.Write(sm.Manifest)
+	return buf.Bytes()
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler.
+//
+// The layout, with all integers big-endian, is:
+//
+//	magic "CHNS" | version uint8 | signer length uint8 | signer |
+//	timestamp int64 (Unix nanoseconds) | signature | manifest length uint32 |
+//	manifest
+func (sm *SignedManifest) MarshalBinary() ([]byte, error) {
+	if len(sm.Signer) > 255 || len(sm.Signature) != ed25519.SignatureSize {
+		return nil, ErrInvalidArgs
+	}
+
+	buf := bytes.NewBuffer(nil)
+	buf.Write(signedMagic[:])
+	buf.WriteByte(signedVersion)
+	buf.WriteByte(byte(len(sm.Signer)))
+	buf.WriteString(sm.Signer)
+	binary.Write(buf, binary.BigEndian, sm.Timestamp.UnixNano())
+	buf.Write(sm.Signature)
+	binary.Write(buf, binary.BigEndian, uint32(len(sm.Manifest)))
+	buf.Write(sm.Manifest)
+	return buf.Bytes(), nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler.
+// The signature is not verified, see Verify.
+func (sm *SignedManifest) UnmarshalBinary(b []byte) error {
+	r := bytes.NewReader(b)
+
+	var magic [4]byte
+	if _, err := io.ReadFull(r, magic[:]); err != nil {
+		return ErrManifestTruncated
+	}
+	if magic != signedMagic {
+		return ErrManifestMagic
+	}
+	version, err := r.ReadByte()
+	if err != nil {
+		return ErrManifestTruncated
+	}
+	if version != signedVersion {
+		return ErrManifestVersion
+	}
+
+	l, err := r.ReadByte()
+	if err != nil {
+		return ErrManifestTruncated
+	}
+	signer := make([]byte, l)
+	if _, err := io.ReadFull(r, signer); err != nil {
+		return ErrManifestTruncated
+	}
+	var ts int64
+	var n uint32
+	sig := make([]byte, ed25519.SignatureSize)
+	if binary.Read(r, binary.BigEndian, &ts) != nil {
+		return ErrManifestTruncated
+	}
+	if _, err := io.ReadFull(r, sig); err != nil {
+		return ErrManifestTruncated
+	}
+	if binary.Read(r, binary.BigEndian, &n) != nil {
+		return ErrManifestTruncated
+	}
+	if int64(n) > int64(r.Len()) {
+		return ErrManifestTruncated
+	}
+	if int64(n) < int64(r.Len()) {
+		return ErrManifestTrailingData
+	}
+	manifest := make([]byte, n)
+	io.ReadFull(r, manifest)
+
+	*sm = SignedManifest{
+		manifest,
+		string(signer),
+		time.Unix(0, ts).UTC(),
+		sig,
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package chunk
+
+import (
+	"crypto/ed25519"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSignedManifest(t *testing.T) {
+	m := metadataFromFile(t, "testdata/all", 30)
+	pub, priv, err := ed25519.GenerateKey(nil)
+	assert.Nil(t, err)
+	otherPub, otherPriv, err := ed25519.GenerateKey(nil)
+	assert.Nil(t, err)
+	trusted := TrustedKeys{"alice": pub, "bob": otherPub}
+
+	sm, err := Sign(m, "alice", priv)
+	assert.Nil(t, err)
+	m2, err := sm.Verify(trusted)
+	assert.Nil(t, err)
+	assert.Equal(t, *m, *m2)
+
+	// binary and JSON round trips
+	b, err := sm.MarshalBinary()
+	assert.Nil(t, err)
+	var sm2 SignedManifest
+	assert.Nil(t, sm2.UnmarshalBinary(b))
+	assert.Equal(t, *sm, sm2)
+	_, err = sm2.Verify(trusted)
+	assert.Nil(t, err)
+
+	assert.Equal(t, ErrManifestTruncated, sm2.UnmarshalBinary(b[:len(b)-1]))
+	assert.Equal(t, ErrManifestTrailingData, sm2.UnmarshalBinary(append(b, 0)))
+
+	b, err = json.Marshal(sm)
+	assert.Nil(t, err)
+	var sm3 SignedManifest
+	assert.Nil(t, json.Unmarshal(b, &sm3))
+	_, err = sm3.Verify(trusted)
+	assert.Nil(t, err)
+
+	// tampered manifest
+	bad := *sm
+	bad.Manifest = append([]byte(nil), sm.Manifest...)
+	bad.Manifest[len(bad.Manifest)-10] ^= 1
+	_, err = bad.Verify(trusted)
+	assert.Equal(t, ErrBadSignature, err)
+
+	// signer or timestamp changed
+	bad = *sm
+	bad.Signer = "bob"
+	_, err = bad.Verify(trusted)
+	assert.Equal(t, ErrBadSignature, err)
+	bad = *sm
+	bad.Timestamp = bad.Timestamp.Add(1)
+	_, err = bad.Verify(trusted)
+	assert.Equal(t, ErrBadSignature, err)
+
+	// signed with a key not trusted for the signer
+	sm, err = Sign(m, "alice", otherPriv)
+	assert.Nil(t, err)
+	_, err = sm.Verify(trusted)
+	assert.Equal(t, ErrBadSignature, err)
+
+	sm, err = Sign(m, "mallory", priv)
+	assert.Nil(t, err)
+	_, err = sm.Verify(trusted)
+	assert.Equal(t, ErrUntrustedSigner, err)
+}
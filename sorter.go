@@ -17,5 +17,15 @@ func (bri byReverseIndex) Swap(i, j int) {
 
 type indexedC struct {
 	C
-	idx int
+	idx  int
+	held *held // content while waiting in the sorter, b is nil until then
+}
+
+// held is the content of a chunk waiting in the sorter, shared by the copies
+// queued under every index of the chunk, so that it is counted, spilled and
+// read back once for all of them.
+type held struct {
+	b       []byte
+	spilled string // file holding the content if b was spilled to disk
+	refs    int    // copies in the sorter
 }
@@ -0,0 +1,168 @@
+package chunk
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// BufferStats reports on the out of order chunks a Reconstructor or a
+// BlindReconstructor holds until their turn to be written comes.
+type BufferStats struct {
+	Buffered      int   // chunks waiting to be written
+	BufferedBytes int64 // bytes of the waiting chunks held in memory
+	PeakBytes     int64 // highest BufferedBytes so far
+	DiskBytes     int64 // bytes of the waiting chunks held on disk
+
+	Spilled      int   // chunks spilled to disk so far
+	SpilledBytes int64 // bytes spilled to disk so far
+
+	// Err is the first error met spilling a chunk, after which chunks are
+	// kept in memory whatever the limit.
+	Err error
+}
+
+// spiller moves waiting chunks to disk once they take more than maxMem bytes
+// of memory.
+type spiller struct {
+	dir    string // parent of tmp
+	maxMem int64  // spilling disabled if 0
+	tmp    string // created on the first spill
+	n      int    // spill file counter
+	stats  BufferStats
+}
+
+// setSpill configures spilling, see Reconstructor.SetSpill.
+func (rec *reconstructor) setSpill(dir string, maxMem int64) error {
+	if maxMem < 0 {
+		return ErrInvalidArgs
+	}
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	if rec.fin {
+		return ErrFinishedReconstructor
+	}
+	rec.spill.dir = dir
+	rec.spill.maxMem = maxMem
+	rec.spillOver()
+	return nil
+}
+
+func (rec *reconstructor) bufferStats() BufferStats {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	return rec.spill.stats
+}
+
+// assume external lock
+// buffer appends c to the sorter, which must be sorted again before use. The
+// content of c is only counted for the first of the copies sharing c.held.
+func (rec *reconstructor) buffer(c *indexedC) {
+	c.b = nil
+	rec.sorter = append(rec.sorter, c)
+	st := &rec.spill.stats
+	st.Buffered++
+	if c.held.refs++; c.held.refs > 1 {
+		return
+	}
+	st.BufferedBytes += int64(len(c.held.b))
+	if st.BufferedBytes > st.PeakBytes {
+		st.PeakBytes = st.BufferedBytes
+	}
+}
+
+// assume external lock
+// spillOver spills the chunks furthest from being written, which come first
+// in the sorter, until those left in memory fit within the limit. The last
+// chunk, the next to be written, is never spilled, nor are its copies.
+func (rec *reconstructor) spillOver() {
+	sp := &rec.spill
+	for i := 0; i < len(rec.sorter)-1 && sp.maxMem > 0 && sp.stats.BufferedBytes > sp.maxMem; i++ {
+		h := rec.sorter[i].held
+		if h.spilled != "" || len(h.b) == 0 || h == rec.sorter[len(rec.sorter)-1].held {
+			continue
+		}
+		if err := sp.write(h); err != nil {
+			sp.stats.Err = err
+			sp.maxMem = 0
+			return
+		}
+	}
+}
+
+// write moves the content of h to a file.
+func (sp *spiller) write(h *held) error {
+	if sp.tmp == "" {
+		tmp, err := ioutil.TempDir(sp.dir, "chunk-spill-")
+		if err != nil {
+			return err
+		}
+		sp.tmp = tmp
+	}
+	name := filepath.Join(sp.tmp, strconv.Itoa(sp.n))
+	if err := ioutil.WriteFile(name, h.b, 0600); err != nil {
+		os.Remove(name)
+		return err
+	}
+	sp.n++
+
+	n := int64(len(h.b))
+	sp.stats.BufferedBytes -= n
+	sp.stats.DiskBytes += n
+	sp.stats.Spilled++
+	sp.stats.SpilledBytes += n
+	h.spilled = name
+	h.b = nil
+	return nil
+}
+
+// assume external lock
+// unbuffer accounts for c leaving the sorter and gives it its content back,
+// read from disk if it was spilled. The content stops being counted, and its
+// file is removed, once the last of the copies sharing c.held has left.
+func (rec *reconstructor) unbuffer(c *indexedC) error {
+	st := &rec.spill.stats
+	st.Buffered--
+	h := c.held
+	h.refs--
+	if h.spilled == "" {
+		c.b = h.b
+		if h.refs == 0 {
+			st.BufferedBytes -= int64(len(h.b))
+		}
+		return nil
+	}
+
+	b, err := ioutil.ReadFile(h.spilled)
+	if h.refs == 0 {
+		os.Remove(h.spilled)
+		st.DiskBytes -= int64(len(b))
+	}
+	if err != nil {
+		return err
+	}
+	c.b = b
+	return nil
+}
+
+// assume external lock
+// dropBuffered forgets every waiting chunk.
+func (rec *reconstructor) dropBuffered() {
+	rec.sorter = nil // deref all unprocessed chunks for gc
+	st := &rec.spill.stats
+	st.Buffered = 0
+	st.BufferedBytes = 0
+	st.DiskBytes = 0
+	rec.removeSpill()
+}
+
+// assume external lock
+// removeSpill removes the spill directory, once nothing is left to write.
+func (rec *reconstructor) removeSpill() {
+	if rec.spill.tmp != "" {
+		os.RemoveAll(rec.spill.tmp)
+		rec.spill.tmp = ""
+	}
+	rec.spill.maxMem = 0
+}
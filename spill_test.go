@@ -0,0 +1,170 @@
+package chunk
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReconstructorSpill(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spilltest")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	var cs []*C
+	var sums []Sum224
+	for i := 1; i <= 5; i++ {
+		c := cFromFile(t, "testdata/chunk"+strconv.Itoa(i))
+		cs = append(cs, c)
+		sums = append(sums, c.Sum224())
+	}
+
+	out := noopCloseWriteCloser{bytes.NewBuffer(nil), &sync.Mutex{}}
+	rec := ReconstructContext(context.Background(), out, sums)
+	assert.Nil(t, rec.SetSpill(dir, 1))
+	assert.Equal(t, ErrInvalidArgs, rec.SetSpill(dir, -1))
+
+	// chunk 0 last, everything else waits
+	for i := 4; i > 0; i-- {
+		assert.Nil(t, rec.Submit(cs[i]))
+	}
+	st := rec.BufferStats()
+	assert.Nil(t, st.Err)
+	assert.Equal(t, 4, st.Buffered)
+	assert.Equal(t, 3, st.Spilled) // all but the one closest to being written
+	assert.Equal(t, int64(len(cs[2].b)+len(cs[3].b)+len(cs[4].b)), st.SpilledBytes)
+	assert.Equal(t, st.SpilledBytes, st.DiskBytes)
+	assert.Equal(t, int64(len(cs[1].b)), st.BufferedBytes)
+	assert.Equal(t, int64(len(cs[1].b)+len(cs[2].b)), st.PeakBytes)
+	files, _ := filepath.Glob(filepath.Join(dir, "chunk-spill-*", "*"))
+	assert.Equal(t, 3, len(files))
+
+	assert.Nil(t, rec.Submit(cs[0]))
+	<-rec.Done()
+	fin, err := rec.Err()
+	assert.True(t, fin)
+	assert.Nil(t, err)
+	assert.Equal(t, "Package bytes implements functions for the manipulation of byte slices. It is analogous to the facilities of the strings package.",
+		out.String())
+
+	st = rec.BufferStats()
+	assert.Equal(t, 0, st.Buffered)
+	assert.Equal(t, int64(0), st.DiskBytes)
+	assert.Equal(t, 4, st.Spilled) // chunk 1 too, pushed away by chunk 0
+	left, _ := ioutil.ReadDir(dir)
+	assert.Equal(t, 0, len(left))
+}
+
+func TestReconstructorSpillCorrupt(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spilltest")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	c1, c2, c3 := cFromFile(t, "testdata/chunk1"), cFromFile(t, "testdata/chunk2"), cFromFile(t, "testdata/chunk3")
+	out := noopCloseWriteCloser{bytes.NewBuffer(nil), &sync.Mutex{}}
+	rec := ReconstructContext(context.Background(), out, []Sum224{c1.Sum224(), c2.Sum224(), c3.Sum224()})
+	assert.Nil(t, rec.SetSpill(dir, 1))
+
+	assert.Nil(t, rec.Submit(c3))
+	assert.Nil(t, rec.Submit(c2))
+	files, _ := filepath.Glob(filepath.Join(dir, "chunk-spill-*", "*"))
+	assert.Equal(t, 1, len(files))
+	assert.Nil(t, ioutil.WriteFile(files[0], []byte("garbage"), 0600))
+
+	rec.Submit(c1)
+	<-rec.Done()
+	_, err = rec.Err()
+	assert.True(t, errors.Is(err, ErrChunkChecksum))
+	assert.Equal(t, 2, err.(*ChunkError).Index)
+	left, _ := ioutil.ReadDir(dir)
+	assert.Equal(t, 0, len(left))
+}
+
+func TestReconstructorSpillRepeated(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spilltest")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	c1, c2 := cFromFile(t, "testdata/chunk1"), cFromFile(t, "testdata/chunk2")
+	rc := cFromFile(t, "testdata/repeated-chunk")
+	n := int64(len(rc.b))
+	out := noopCloseWriteCloser{bytes.NewBuffer(nil), &sync.Mutex{}}
+	rec := ReconstructContext(context.Background(), out,
+		[]Sum224{c1.Sum224(), c2.Sum224(), rc.Sum224(), rc.Sum224(), rc.Sum224()})
+	assert.Nil(t, rec.SetSpill(dir, 1))
+
+	// one copy in memory for 3 indexes, kept as it is the next to be written
+	assert.Nil(t, rec.Submit(rc))
+	st := rec.BufferStats()
+	assert.Equal(t, 3, st.Buffered)
+	assert.Equal(t, n, st.BufferedBytes)
+	assert.Equal(t, 0, st.Spilled)
+
+	// spilled once for all 3 indexes
+	assert.Nil(t, rec.Submit(c2))
+	st = rec.BufferStats()
+	assert.Equal(t, 4, st.Buffered)
+	assert.Equal(t, 1, st.Spilled)
+	assert.Equal(t, n, st.DiskBytes)
+	assert.Equal(t, int64(len(c2.b)), st.BufferedBytes)
+	assert.Equal(t, n+int64(len(c2.b)), st.PeakBytes)
+	files, _ := filepath.Glob(filepath.Join(dir, "chunk-spill-*", "*"))
+	assert.Equal(t, 1, len(files))
+
+	assert.Nil(t, rec.Submit(c1))
+	<-rec.Done()
+	fin, err := rec.Err()
+	assert.True(t, fin)
+	assert.Nil(t, err)
+	c1data, _ := ioutil.ReadFile("testdata/chunk1")
+	c2data, _ := ioutil.ReadFile("testdata/chunk2")
+	repeated, _ := ioutil.ReadFile("testdata/repeated")
+	assert.Equal(t, string(c1data)+string(c2data)+string(repeated), out.String())
+
+	st = rec.BufferStats()
+	assert.Equal(t, 0, st.Buffered)
+	assert.Equal(t, int64(0), st.BufferedBytes)
+	assert.Equal(t, int64(0), st.DiskBytes)
+	left, _ := ioutil.ReadDir(dir)
+	assert.Equal(t, 0, len(left))
+}
+
+func TestBlindReconstructorSpill(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spilltest")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	out := noopCloseWriteCloser{bytes.NewBuffer(nil), &sync.Mutex{}}
+	br := BlindReconstruct(out, time.Second)
+	c3, c4 := cFromFile(t, "testdata/chunk3"), cFromFile(t, "testdata/chunk4")
+	// room for one of them
+	assert.Nil(t, br.SetSpill(dir, int64(len(c3.b))))
+
+	assert.Nil(t, br.Submit(c3, 2))
+	assert.Equal(t, 0, br.BufferStats().Spilled)
+	assert.Nil(t, br.Submit(c4, 3))
+	st := br.BufferStats()
+	assert.Equal(t, 1, st.Spilled)
+	assert.Equal(t, int64(len(c4.b)), st.DiskBytes)
+	assert.Equal(t, int64(len(c3.b)), st.BufferedBytes)
+
+	assert.Nil(t, br.Submit(cFromFile(t, "testdata/chunk1"), 0))
+	assert.Nil(t, br.Submit(cFromFile(t, "testdata/chunk2"), 1))
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, 0, br.BufferStats().Buffered)
+	assert.Nil(t, br.Close())
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, "Package bytes implements functions for the manipulation of byte slices. It is analogous to the facilities of the strings",
+		out.String())
+	left, _ := ioutil.ReadDir(dir)
+	assert.Equal(t, 0, len(left))
+}
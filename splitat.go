@@ -0,0 +1,206 @@
+package chunk
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"runtime"
+	"sync"
+)
+
+// SplitAt cuts up the size first bytes of ra according to sp. It behaves like
+// Split, and produces the same chunks and Metadata, but reads, checksums,
+// compresses and seals up to sp.Workers chunks concurrently. Chunks are still
+// returned by Next in order.
+// By default the top checksum, being the checksum of the whole input, is
+// computed as chunks are handed over in order, while every other step runs on
+// the workers. With sp.MerkleTop, the top checksum is the Merkle root of the
+// chunk checksums computed by the workers, and nothing is hashed in order.
+// Content-defined boundaries are found sequentially too, which is cheap next
+// to checksumming; fixed width chunks are read by the workers directly.
+// ra is not closed.
+// It returns nil if sp is invalid, sp.Timeout<1ms, ra==nil or size<0.
+func (sp *Splitter) SplitAt(ra io.ReaderAt, size int64) *Sequence {
+	if sp.Timeout.Nanoseconds() < 1000*1000 {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), sp.Timeout)
+	return sp.splitAt(ctx, cancel, ra, size)
+}
+
+// SplitAtContext is like SplitAt but runs until ctx is done. sp.Timeout is
+// ignored.
+func (sp *Splitter) SplitAtContext(ctx context.Context, ra io.ReaderAt, size int64) *Sequence {
+	ctx, cancel := context.WithCancel(ctx)
+	return sp.splitAt(ctx, cancel, ra, size)
+}
+
+func (sp *Splitter) splitAt(ctx context.Context, cancel context.CancelFunc,
+	ra io.ReaderAt, size int64) *Sequence {
+
+	if ra == nil || size < 0 || !sp.valid() {
+		cancel()
+		return nil
+	}
+
+	// next returns the length of the chunk at off, along with its content if
+	// it had to be read to find its length
+	var next func(off int64) (int64, []byte, error)
+	if sp.MaxWidth > 0 {
+		bufSize := readBufferSize
+		if sp.MaxWidth > int64(bufSize) {
+			bufSize = int(sp.MaxWidth)
+		}
+		br := bufio.NewReaderSize(io.NewSectionReader(ra, 0, size), bufSize)
+		cut := newCDC(sp.MinWidth, sp.AvgWidth, sp.MaxWidth)
+		next = func(off int64) (int64, []byte, error) {
+			buf, err := br.Peek(int(cut.max))
+			if err != nil && err != io.EOF {
+				return 0, nil, err
+			}
+			if len(buf) == 0 && off < size {
+				return 0, nil, io.ErrUnexpectedEOF
+			}
+			b := append([]byte(nil), buf[:cut.cut(buf)]...)
+			_, err = br.Discard(len(b))
+			return int64(len(b)), b, err
+		}
+	} else {
+		next = func(off int64) (int64, []byte, error) {
+			if n := size - off; n < sp.Width {
+				return n, nil, nil
+			}
+			return sp.Width, nil, nil
+		}
+	}
+
+	workers := sp.Workers
+	if workers < 1 {
+		workers = runtime.NumCPU()
+	}
+
+	s := sp.newSequence(ctx)
+	go s.runAt(ctx, cancel, ra, size, next, workers)
+	return s
+}
+
+// chunkJob is a chunk for a worker of runAt to process, and its outcome.
+type chunkJob struct {
+	off, n int64
+	b      []byte // content, read by the worker if nil
+
+	res chan chunkResult // buffered
+}
+
+type chunkResult struct {
+	c     *C
+	plain []byte // content before compression and sealing
+	weak  uint32
+	err   error
+}
+
+// runAt feeds s with the chunks of the size first bytes of ra, whose lengths
+// are given by next, processed by workers goroutines, until the end of the
+// input, an error or ctx is done.
+func (s *Sequence) runAt(ctx context.Context, cancel context.CancelFunc,
+	ra io.ReaderAt, size int64, next func(off int64) (int64, []byte, error), workers int) {
+
+	jobs := make(chan chunkJob)
+	order := make(chan chan chunkResult, workers) // results in input order
+	wg := sync.WaitGroup{}
+	defer func() {
+		cancel()
+		wg.Wait()
+		close(s.c)
+		close(s.done)
+	}()
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer close(jobs)
+		defer close(order)
+		for off := int64(0); off < size; {
+			n, b, err := next(off)
+			res := make(chan chunkResult, 1)
+			if err != nil {
+				res <- chunkResult{err: err}
+			}
+			select {
+			case order <- res:
+			case <-ctx.Done():
+				return
+			}
+			if err != nil {
+				return
+			}
+			select {
+			case jobs <- chunkJob{off, n, b, res}:
+			case <-ctx.Done():
+				return
+			}
+			off += n
+		}
+	}()
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				j.res <- s.process(ra, j)
+			}
+		}()
+	}
+
+	for res := range order {
+		var r chunkResult
+		select {
+		case r = <-res:
+		case <-ctx.Done():
+			s.doneWith(ctx.Err())
+			return
+		}
+		if r.err != nil {
+			s.doneWith(r.err)
+			return
+		}
+		if !s.mtop {
+			s.h224.Write(r.plain)
+		}
+		if !s.push(r.c, int64(len(r.plain)), r.weak) {
+			s.doneWith(ctx.Err())
+			return
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		s.doneWith(err)
+		return
+	}
+	s.doneWith(nil)
+}
+
+// process reads, checksums and encodes the chunk of j.
+func (s *Sequence) process(ra io.ReaderAt, j chunkJob) chunkResult {
+	b := j.b
+	if b == nil {
+		b = make([]byte, j.n)
+		n, err := ra.ReadAt(b, j.off)
+		if n < len(b) {
+			if err == nil || err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return chunkResult{err: err}
+		}
+	}
+
+	h := s.alg.New()
+	h.Write(b)
+	rs := &rollsum{}
+	rs.Write(b)
+	c, err := s.encode(&C{b, h, s.alg, nil, false})
+	if err != nil {
+		return chunkResult{err: err}
+	}
+	return chunkResult{c, b, rs.Sum32(), nil}
+}
@@ -0,0 +1,109 @@
+package chunk
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSplitAt(t *testing.T) {
+	data := make([]byte, 50*1000+321)
+	rand.New(rand.NewSource(12)).Read(data)
+
+	for _, sp := range []*Splitter{
+		{Width: 1000},
+		{Width: 100 * 1000},
+		{Hash: BLAKE2b256, Width: 777, WeakChecksums: true, Merkle: true},
+		{MinWidth: 256, AvgWidth: 1024, MaxWidth: 4096, Codec: Flate},
+		{Width: 1000, MerkleTop: true},
+		{MinWidth: 256, AvgWidth: 1024, MaxWidth: 4096, MerkleTop: true},
+	} {
+		sp.Timeout = time.Second
+		s := sp.Split(ioutil.NopCloser(bytes.NewReader(data)))
+		var want []*C
+		for c := s.Next(); c != nil; c = s.Next() {
+			want = append(want, c)
+		}
+		wm, err := s.Metadata()
+		assert.Nil(t, err)
+		if sp.MerkleTop {
+			assert.True(t, wm.MerkleTop)
+			assert.Equal(t, MerkleRoot(wm.Hash, wm.ChunkChecksums), wm.TopChecksum)
+			assert.Equal(t, wm.TopChecksum, *wm.MerkleRoot)
+		}
+
+		for _, workers := range []int{0, 1, 3} {
+			sp.Workers = workers
+			s = sp.SplitAt(bytes.NewReader(data), int64(len(data)))
+			var got []*C
+			for c := s.Next(); c != nil; c = s.Next() {
+				got = append(got, c)
+			}
+			fin, err := s.Err()
+			assert.True(t, fin)
+			assert.Nil(t, err)
+			assert.Equal(t, len(want), len(got))
+			for i := range want {
+				assert.Equal(t, want[i].Sum224(), got[i].Sum224())
+				assert.Equal(t, want[i].b, got[i].b)
+			}
+			m, err := s.Metadata()
+			assert.Nil(t, err)
+			assert.Equal(t, wm, m)
+		}
+	}
+
+	// empty input
+	sp := &Splitter{Width: 10, Timeout: time.Second}
+	s := sp.SplitAt(bytes.NewReader(nil), 0)
+	assert.Nil(t, s.Next())
+	m, err := s.Metadata()
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(m.ChunkChecksums))
+	assert.True(t, m.TopChecksum.EqB(SHA224.New().Sum(nil)))
+
+	assert.Nil(t, sp.SplitAt(nil, 0))
+	assert.Nil(t, sp.SplitAt(bytes.NewReader(nil), -1))
+}
+
+func TestSplitAtErrors(t *testing.T) {
+	data := make([]byte, 10*1000)
+
+	// size beyond the end of the input
+	sp := &Splitter{Width: 1000, Workers: 2, Timeout: time.Second}
+	s := sp.SplitAt(bytes.NewReader(data), 20*1000)
+	n := 0
+	for c := s.Next(); c != nil; c = s.Next() {
+		n++
+	}
+	assert.Equal(t, 10, n)
+	_, err := s.Err()
+	assert.True(t, errors.Is(err, io.ErrUnexpectedEOF))
+
+	// with content-defined boundaries too, without a deadline
+	sp = &Splitter{MinWidth: 256, AvgWidth: 1024, MaxWidth: 4096, Workers: 2}
+	s = sp.SplitAtContext(context.Background(), bytes.NewReader(data), 20*1000)
+	for c := s.Next(); c != nil; c = s.Next() {
+	}
+	_, err = s.Err()
+	assert.True(t, errors.Is(err, io.ErrUnexpectedEOF))
+	sp = &Splitter{Width: 1000, Workers: 2, Timeout: time.Second}
+
+	// cancelled
+	ctx, cancel := context.WithCancel(context.Background())
+	s = sp.SplitAtContext(ctx, bytes.NewReader(data), int64(len(data)))
+	assert.NotNil(t, s.Next())
+	cancel()
+	for c := s.Next(); c != nil; c = s.Next() {
+	}
+	<-s.done
+	_, err = s.Err()
+	assert.True(t, errors.Is(err, context.Canceled))
+}
@@ -0,0 +1,137 @@
+package chunk
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+)
+
+// splitStateMagic prefixes every binary encoded SplitState.
+var splitStateMagic = [4]byte{'C', 'H', 'N', 'R'}
+
+// splitStateVersion is the version of the binary split state format.
+const splitStateVersion = 1
+
+// SplitState is the progress of a Sequence, from which splitting the rest of
+// its input can resume, see Sequence.State and Splitter.Resume.
+type SplitState struct {
+	// Metadata describes the chunks split so far. Its Size is the offset of
+	// the input splitting resumes at. It has no TopChecksum nor MerkleRoot,
+	// except with Splitter.MerkleTop, the Merkle root of the chunks so far.
+	Metadata *Metadata
+
+	// HashState is the state of the checksum of the input up to
+	// Metadata.Size, as marshaled by the hash.Hash of Metadata.Hash.
+	HashState []byte
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler.
+//
+// The layout, with all integers big-endian, is:
+//
+//	magic "CHNR" | version uint8 | manifest length uint32 | manifest |
+//	hash state length uint32 | hash state
+//
+// where the manifest is the binary encoding of st.Metadata.
+func (st *SplitState) MarshalBinary() ([]byte, error) {
+	if st.Metadata == nil {
+		return nil, ErrInvalidMetadata
+	}
+	m, err := st.Metadata.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	buf := bytes.NewBuffer(nil)
+	buf.Write(splitStateMagic[:])
+	buf.WriteByte(splitStateVersion)
+	binary.Write(buf, binary.BigEndian, uint32(len(m)))
+	buf.Write(m)
+	binary.Write(buf, binary.BigEndian, uint32(len(st.HashState)))
+	buf.Write(st.HashState)
+	return buf.Bytes(), nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler.
+// b must hold exactly one split state as produced by MarshalBinary, otherwise
+// an error is returned and st is left untouched.
+func (st *SplitState) UnmarshalBinary(b []byte) error {
+	r := bytes.NewReader(b)
+
+	var magic [4]byte
+	if _, err := io.ReadFull(r, magic[:]); err != nil {
+		return ErrManifestTruncated
+	}
+	if magic != splitStateMagic {
+		return ErrManifestMagic
+	}
+	version, err := r.ReadByte()
+	if err != nil {
+		return ErrManifestTruncated
+	}
+	if version != splitStateVersion {
+		return ErrManifestVersion
+	}
+
+	var parts [2][]byte
+	for i := range parts {
+		var n uint32
+		if err := binary.Read(r, binary.BigEndian, &n); err != nil {
+			return ErrManifestTruncated
+		}
+		if int64(n) > int64(r.Len()) {
+			return ErrManifestTruncated
+		}
+		parts[i] = make([]byte, n)
+		io.ReadFull(r, parts[i])
+	}
+	if r.Len() > 0 {
+		return ErrManifestTrailingData
+	}
+
+	m := &Metadata{}
+	if err := m.UnmarshalBinary(parts[0]); err != nil {
+		return err
+	}
+	st.Metadata = m
+	st.HashState = parts[1]
+	return nil
+}
+
+// matches reports whether st is consistent and was recorded with the same
+// hash algorithm, chunk width or bounds, top checksum and weak checksum
+// settings as sp.
+func (st *SplitState) matches(sp *Splitter) bool {
+	m := st.Metadata
+	if m == nil || m.validate() != nil || st.HashState == nil || m.Hash != sp.Hash ||
+		m.MerkleTop != sp.MerkleTop {
+		return false
+	}
+	width, bounds := sp.Width, [3]int64{}
+	if sp.MaxWidth > 0 {
+		width, bounds = 0, [3]int64{sp.MinWidth, sp.AvgWidth, sp.MaxWidth}
+	}
+	if m.Width != width || [3]int64{m.MinWidth, m.AvgWidth, m.MaxWidth} != bounds {
+		return false
+	}
+	if sp.WeakChecksums {
+		return len(m.WeakChecksums) == len(m.ChunkChecksums)
+	}
+	return len(m.WeakChecksums) == 0
+}
+
+// restore records the chunks of m as already split and returned by Next.
+func (s *Sequence) restore(m *Metadata) {
+	for i, v := range m.ChunkChecksums {
+		s.chunks224 = append(s.chunks224, knownSum(v))
+		s.lengths = append(s.lengths, m.ChunkLengths[i])
+		if m.ChunkCodecs != nil {
+			s.codecs = append(s.codecs, m.ChunkCodecs[i])
+		} else {
+			s.codecs = append(s.codecs, "")
+		}
+		if s.weak {
+			s.weak32 = append(s.weak32, m.WeakChecksums[i])
+		}
+	}
+	s.taken = len(m.ChunkChecksums)
+}
@@ -0,0 +1,99 @@
+package chunk
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSplitResume(t *testing.T) {
+	data := make([]byte, 20*1000+77)
+	rand.New(rand.NewSource(11)).Read(data)
+
+	for _, sp := range []*Splitter{
+		{Width: 1000},
+		{Hash: SHA512_256, Width: 1000, WeakChecksums: true, Merkle: true},
+		{MinWidth: 256, AvgWidth: 1024, MaxWidth: 4096, Codec: Gzip},
+		{Width: 1000, MerkleTop: true},
+	} {
+		sp.Timeout = time.Second
+
+		// uninterrupted
+		s := sp.Split(ioutil.NopCloser(bytes.NewReader(data)))
+		var all []*C
+		for c := s.Next(); c != nil; c = s.Next() {
+			all = append(all, c)
+		}
+		want, err := s.Metadata()
+		assert.Nil(t, err)
+
+		// interrupted after 5 chunks
+		ctx, cancel := context.WithCancel(context.Background())
+		s = sp.SplitContext(ctx, ioutil.NopCloser(bytes.NewReader(data)))
+		for i := 0; i < 5; i++ {
+			assert.NotNil(t, s.Next())
+		}
+		st, err := s.State()
+		assert.Nil(t, err)
+		cancel()
+		assert.Equal(t, 5, len(st.Metadata.ChunkChecksums))
+		assert.Equal(t, want.ChunkOffsets[5], st.Metadata.Size)
+
+		b, err := st.MarshalBinary()
+		assert.Nil(t, err)
+		st = &SplitState{}
+		assert.Nil(t, st.UnmarshalBinary(b))
+
+		s = sp.Resume(context.Background(), ioutil.NopCloser(bytes.NewReader(data[st.Metadata.Size:])), st)
+		var rest []*C
+		for c := s.Next(); c != nil; c = s.Next() {
+			rest = append(rest, c)
+		}
+		fin, err := s.Err()
+		assert.True(t, fin)
+		assert.Nil(t, err)
+		assert.Equal(t, all[5:], rest)
+		m, err := s.Metadata()
+		assert.Nil(t, err)
+		assert.Equal(t, want, m)
+
+		// the state at the end resumes to the same result
+		st, err = s.State()
+		assert.Nil(t, err)
+		s = sp.Resume(context.Background(), ioutil.NopCloser(bytes.NewReader(nil)), st)
+		assert.Nil(t, s.Next())
+		m, err = s.Metadata()
+		assert.Nil(t, err)
+		assert.Equal(t, want, m)
+	}
+}
+
+func TestSplitResumeMismatch(t *testing.T) {
+	sp := &Splitter{Width: 10, Timeout: time.Second}
+	s := sp.Split(ioutil.NopCloser(bytes.NewReader(make([]byte, 100))))
+	s.Next()
+	st, err := s.State()
+	assert.Nil(t, err)
+
+	for _, other := range []*Splitter{
+		{Width: 20},
+		{Width: 10, Hash: SHA256},
+		{Width: 10, WeakChecksums: true},
+		{Width: 10, MerkleTop: true},
+		{MinWidth: 5, AvgWidth: 10, MaxWidth: 20},
+	} {
+		assert.Nil(t, other.Resume(context.Background(), ioutil.NopCloser(bytes.NewReader(nil)), st))
+	}
+	assert.NotNil(t, sp.Resume(context.Background(), ioutil.NopCloser(bytes.NewReader(nil)), st))
+
+	sp = &Splitter{Hash: XXH3, Width: 10, Timeout: time.Second}
+	s = sp.Split(ioutil.NopCloser(bytes.NewReader(make([]byte, 100))))
+	s.Next()
+	_, err = s.State()
+	assert.Equal(t, ErrHashState, err)
+}
@@ -0,0 +1,189 @@
+package chunk
+
+import (
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Store is a content-addressed repository of chunks keyed by their checksum.
+// Implementations must be thread safe.
+type Store interface {
+	// Put stores c. Putting a chunk which is already stored does nothing.
+	Put(c *C) error
+
+	// Get returns the chunk whose checksum is sum.
+	Get(sum Sum224) (*C, error)
+
+	// Has reports whether the chunk whose checksum is sum is stored.
+	Has(sum Sum224) (bool, error)
+
+	// Delete removes the chunk whose checksum is sum.
+	// Deleting a chunk which is not stored does nothing.
+	Delete(sum Sum224) error
+}
+
+// FileStore is a Store keeping every chunk in its own file under a root
+// directory. Files are sharded into two levels of directories named after the
+// first two bytes of the checksum, e.g. root/6b/cc/6bcc3c...
+// Compressed chunks are kept compressed, in files suffixed with the name of
+// their Codec, e.g. root/6b/cc/6bcc3c....gzip, and sealed chunks are kept
+// sealed, in files further suffixed with .sealed. Sealed chunks cannot be
+// verified by Get, they are only authenticated once opened.
+// Writes are atomic, a chunk file is either complete or absent.
+// It is thread safe.
+type FileStore struct {
+	root string
+	alg  Hash
+}
+
+// NewFileStore returns a FileStore rooted at dir holding chunks checksummed
+// with alg. dir is created if it does not exist.
+func NewFileStore(dir string, alg Hash) (*FileStore, error) {
+	if !alg.Available() {
+		return nil, ErrUnknownHash
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &FileStore{dir, alg}, nil
+}
+
+const sealedExt = ".sealed"
+
+func (fs *FileStore) path(sum Sum224, codec Codec, sealed bool) string {
+	s := sum.String()
+	p := filepath.Join(fs.root, s[:2], s[2:4], s)
+	if codec != nil {
+		p += "." + codec.Name()
+	}
+	if sealed {
+		p += sealedExt
+	}
+	return p
+}
+
+// find returns the path of the file holding the chunk whose checksum is sum,
+// the codec it is compressed with and whether it is sealed, or
+// os.ErrNotExist.
+func (fs *FileStore) find(sum Sum224) (string, Codec, bool, error) {
+	p := fs.path(sum, nil, false)
+	_, err := os.Stat(p)
+	if !os.IsNotExist(err) {
+		return p, nil, false, err
+	}
+
+	matches, err := filepath.Glob(p + ".*")
+	if err != nil {
+		return "", nil, false, err
+	}
+	for _, v := range matches {
+		ext := v[len(p):]
+		sealed := strings.HasSuffix(ext, sealedExt)
+		ext = strings.TrimSuffix(ext, sealedExt)
+		if ext == "" {
+			return v, nil, sealed, nil
+		}
+		if codec, err := CodecByName(ext[1:]); err == nil {
+			return v, codec, sealed, nil
+		}
+	}
+	return "", nil, false, os.ErrNotExist
+}
+
+// Put stores c in its own file, compressed and sealed if c is. c must have
+// been created with the same Hash as fs.
+func (fs *FileStore) Put(c *C) error {
+	if c.alg != fs.alg {
+		return ErrHashMismatch
+	}
+
+	if _, _, _, err := fs.find(c.Sum224()); err == nil {
+		return nil
+	}
+	p := fs.path(c.Sum224(), c.codec, c.sealed)
+
+	dir := filepath.Dir(p)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	f, err := ioutil.TempFile(dir, ".tmp-")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+
+	_, err = f.Write(c.b)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(tmp, 0644)
+	}
+	if err == nil {
+		err = os.Rename(tmp, p)
+	}
+	if err != nil {
+		os.Remove(tmp)
+	}
+	return err
+}
+
+// Get reads the chunk whose checksum is sum and verifies its content unless it
+// is sealed. The returned chunk is compressed and sealed if it was stored so.
+func (fs *FileStore) Get(sum Sum224) (*C, error) {
+	p, codec, sealed, err := fs.find(sum)
+	if os.IsNotExist(err) {
+		return nil, ErrChunkNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(p)
+	if os.IsNotExist(err) {
+		return nil, ErrChunkNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	if sealed {
+		return NewSealedChunk(f, fs.alg, codec, sum)
+	}
+	c, err := NewChunkCodec(f, fs.alg, codec, math.MaxInt64) // files of fs are trusted
+	if err != nil {
+		return nil, err
+	}
+	if !c.Sum224().Eq(sum) {
+		return nil, ErrChunkChecksum
+	}
+	return c, nil
+}
+
+// Has reports whether the chunk whose checksum is sum is stored.
+func (fs *FileStore) Has(sum Sum224) (bool, error) {
+	_, _, _, err := fs.find(sum)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Delete removes the chunk whose checksum is sum.
+func (fs *FileStore) Delete(sum Sum224) error {
+	p, _, _, err := fs.find(sum)
+	if err == nil {
+		err = os.Remove(p)
+	}
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,136 @@
+package chunk
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "chunkstore")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	fs, err := NewFileStore(dir, SHA224)
+	assert.Nil(t, err)
+
+	c := cFromFile(t, "testdata/chunk1")
+	sum := c.Sum224()
+
+	ok, err := fs.Has(sum)
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	_, err = fs.Get(sum)
+	assert.Equal(t, ErrChunkNotFound, err)
+
+	assert.Nil(t, fs.Put(c))
+	assert.Nil(t, fs.Put(c))
+	ok, err = fs.Has(sum)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+
+	// sharded by hash prefix, no temporary files left behind
+	files, err := ioutil.ReadDir(filepath.Join(dir, "d0", "b4"))
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(files))
+	assert.Equal(t, sum.String(), files[0].Name())
+
+	c2, err := fs.Get(sum)
+	assert.Nil(t, err)
+	assert.Equal(t, sum, c2.Sum224())
+
+	// corrupted on disk
+	assert.Nil(t, ioutil.WriteFile(fs.path(sum, nil, false), []byte("garbage"), 0644))
+	_, err = fs.Get(sum)
+	assert.Equal(t, ErrChunkChecksum, err)
+
+	assert.Nil(t, fs.Delete(sum))
+	assert.Nil(t, fs.Delete(sum))
+	ok, err = fs.Has(sum)
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	// different algorithm
+	f, err := os.Open("testdata/chunk1")
+	assert.Nil(t, err)
+	defer f.Close()
+	c3, err := NewChunkHash(f, SHA256)
+	assert.Nil(t, err)
+	assert.Equal(t, ErrHashMismatch, fs.Put(c3))
+}
+
+func TestFileStoreRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "chunkstore")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	fs, err := NewFileStore(dir, SHA224)
+	assert.Nil(t, err)
+
+	f, err := os.Open("testdata/all")
+	assert.Nil(t, err)
+	s := SplitStream(f, 30, 2, 1*time.Second)
+	for c := s.Next(); c != nil; c = s.Next() {
+		assert.Nil(t, fs.Put(c))
+	}
+	m, err := s.Metadata()
+	assert.Nil(t, err)
+
+	out := noopCloseWriteCloser{bytes.NewBuffer(nil), &sync.Mutex{}}
+	rec := ReconstructMetadata(out, m, 1*time.Second)
+	for _, sum := range m.ChunkChecksums {
+		c, err := fs.Get(sum)
+		assert.Nil(t, err)
+		assert.Nil(t, rec.Submit(c))
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	top224, err := rec.Sum224()
+	assert.Nil(t, err)
+	assert.Equal(t, m.TopChecksum, top224)
+}
+
+func TestFileStoreCompressed(t *testing.T) {
+	dir, err := ioutil.TempDir("", "chunkstore")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	fs, err := NewFileStore(dir, SHA224)
+	assert.Nil(t, err)
+
+	c, err := NewChunk(strings.NewReader(strings.Repeat("compressible ", 100)))
+	assert.Nil(t, err)
+	z, err := c.Compress(Flate)
+	assert.Nil(t, err)
+	sum := c.Sum224()
+
+	assert.Nil(t, fs.Put(z))
+	assert.Nil(t, fs.Put(c)) // already stored compressed
+	_, err = os.Stat(fs.path(sum, Flate, false))
+	assert.Nil(t, err)
+	_, err = os.Stat(fs.path(sum, nil, false))
+	assert.True(t, os.IsNotExist(err))
+
+	has, err := fs.Has(sum)
+	assert.Nil(t, err)
+	assert.True(t, has)
+
+	got, err := fs.Get(sum)
+	assert.Nil(t, err)
+	assert.Equal(t, Flate, got.Codec())
+	assert.Equal(t, z.b, got.b)
+	d, err := got.Decompress(int64(len(c.b)))
+	assert.Nil(t, err)
+	assert.Equal(t, c.b, d.b)
+
+	assert.Nil(t, fs.Delete(sum))
+	_, err = fs.Get(sum)
+	assert.Equal(t, ErrChunkNotFound, err)
+}
@@ -4,7 +4,7 @@ import (
 	"bufio"
 	"bytes"
 	"context"
-	"crypto/sha256"
+	"encoding"
 	"hash"
 	"io"
 	"sync"
@@ -14,57 +14,172 @@ import (
 // Sequence represents a sliced up io.Reader into a sequence of smaller chunks.
 // It is thread safe.
 type Sequence struct {
-	c    chan *C
-	w    int64     // read only
-	h224 hash.Hash // accessed from 1 goroutine sequentially
+	c      chan *C
+	done   chan struct{} // closed once the producer has stopped
+	ctx    context.Context
+	w      int64     // read only
+	bounds [3]int64  // read only, content-defined chunking bounds
+	alg    Hash      // read only
+	merkle bool      // read only
+	mtop   bool      // read only, top checksum is the Merkle root
+	codec  Codec     // read only
+	sealer *Sealer   // read only
+	weak   bool      // read only
+	h224   hash.Hash // accessed from 1 goroutine sequentially
 
 	// r/w
 	mu        sync.Mutex
 	fin       bool
 	err       error
 	chunks224 []hash.Hash
+	lengths   []int64
+	codecs    []string
+	weak32    []uint32
+
+	// chunks returned by Next, and the state of h224 right after them, nil
+	// if it cannot be saved; the states after the chunks pushed but not
+	// returned yet are pending
+	taken   int
+	state   []byte
+	pending [][]byte
 }
 
 // Next returns the next data chunk if any.
 // If s has finished consuming from the input io.Reader, the returned chunk is nil.
+// Next also returns nil as soon as the context s runs under is done, even if
+// the producer is still blocked reading the input, which it closes then.
+// Until the producer has actually stopped, Err reports s as unfinished and
+// Metadata and Sum224 fail with ErrStreamStillRunning.
 func (s *Sequence) Next() *C {
-	return <-s.c
+	select {
+	case c := <-s.c:
+		return s.took(c)
+	default:
+	}
+
+	select {
+	case c := <-s.c:
+		return s.took(c)
+	case <-s.ctx.Done():
+		return nil
+	}
+}
+
+// took records that c, if not nil, has been returned by Next.
+func (s *Sequence) took(c *C) *C {
+	if c == nil {
+		return nil
+	}
+	s.mu.Lock()
+	s.taken++
+	s.state, s.pending = s.pending[0], s.pending[1:]
+	s.mu.Unlock()
+	return c
 }
 
 // Sum224 checks whether the processing of the input stream is finished.
-// If it is ongoing, an error is returned.
-// Otherwise, the SHA-224 checksum of the stream is returned with no error.
+// If it is ongoing, an error is returned, as is the error which stopped it.
+// Otherwise, the checksum of the stream, or its Merkle root with
+// Splitter.MerkleTop, is returned with no error.
 func (s *Sequence) Sum224() (Sum224, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	if !s.fin {
-		return Sum224{}, errStreamStillRunning
+		return Sum224{}, ErrStreamStillRunning
+	}
+	if s.err != nil {
+		return Sum224{}, s.err
+	}
+	return s.top(s.metadata(len(s.chunks224))), nil
+}
+
+// assume external lock
+// top returns the top checksum of the chunks of m, the first ones of s.
+func (s *Sequence) top(m *Metadata) Sum224 {
+	if s.mtop {
+		return MerkleRoot(s.alg, m.ChunkChecksums)
 	}
 	var res Sum224
 	copy(res[:], s.h224.Sum(nil))
-	return res, nil
+	return res
 }
 
 // Metadata returns the metadata required to reconstruct the original file.
-// Must only be called after the input stream has stopped, otherwise an error
-// is returned.
+// Must only be called after the input stream has been consumed, otherwise an
+// error is returned: ErrStreamStillRunning, or the error which stopped it.
 func (s *Sequence) Metadata() (*Metadata, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	if !s.fin {
-		return nil, errStreamStillRunning
+		return nil, ErrStreamStillRunning
+	}
+	if s.err != nil {
+		return nil, s.err
 	}
 
+	m := s.metadata(len(s.chunks224))
+	m.TopChecksum = s.top(m)
+	if s.mtop {
+		root := m.TopChecksum
+		m.MerkleRoot = &root
+		m.MerkleTop = true
+	} else if s.merkle {
+		root := MerkleRoot(s.alg, m.ChunkChecksums)
+		m.MerkleRoot = &root
+	}
+	return m, nil
+}
+
+// assume external lock
+// metadata returns the Metadata of the n first chunks, without TopChecksum
+// nor MerkleRoot.
+func (s *Sequence) metadata(n int) *Metadata {
 	m := &Metadata{}
-	copy(m.TopChecksum[:], s.h224.Sum(nil))
-	for _, v := range s.chunks224 {
+	for _, v := range s.chunks224[:n] {
 		var tmp Sum224
 		copy(tmp[:], v.Sum(nil))
 		m.ChunkChecksums = append(m.ChunkChecksums, tmp)
 	}
 	m.Width = s.w
+	m.MinWidth, m.AvgWidth, m.MaxWidth = s.bounds[0], s.bounds[1], s.bounds[2]
+	m.Hash = s.alg
+	for _, v := range s.lengths[:n] {
+		m.ChunkOffsets = append(m.ChunkOffsets, m.Size)
+		m.ChunkLengths = append(m.ChunkLengths, v)
+		m.Size += v
+	}
+	if s.weak {
+		m.WeakChecksums = append([]uint32{}, s.weak32[:n]...)
+	}
+	for _, v := range s.codecs[:n] {
+		if v != "" {
+			m.ChunkCodecs = append([]string(nil), s.codecs[:n]...)
+			break
+		}
+	}
+	return m
+}
 
-	return m, nil
+// State returns the progress of s up to the last chunk returned by Next, from
+// which splitting the rest of the input can resume, see Splitter.Resume. It
+// can be called at any time, even after s has stopped.
+// It fails with ErrHashState if the state of the hash algorithm cannot be
+// saved (XXH3).
+func (s *Sequence) State() (*SplitState, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.state == nil {
+		return nil, ErrHashState
+	}
+	m := s.metadata(s.taken)
+	if s.mtop {
+		// tells Resume how the chunks so far were split
+		m.TopChecksum = s.top(m)
+		root := m.TopChecksum
+		m.MerkleRoot = &root
+		m.MerkleTop = true
+	}
+	return &SplitState{m, s.state}, nil
 }
 
 // Err returns any error encountered when processing the input stream
@@ -78,10 +193,57 @@ func (s *Sequence) Err() (finished bool, err error) {
 	return
 }
 
+// push hands c, n bytes long with weak checksum weak, over to the consumer.
+// It returns false if ctx is done before c could be handed over.
+func (s *Sequence) push(c *C, n int64, weak uint32) bool {
+	var state []byte
+	if bm, ok := s.h224.(encoding.BinaryMarshaler); ok {
+		// left nil for hashes whose state cannot be saved
+		state, _ = bm.MarshalBinary()
+	}
+
+	// recorded first, for Next to find the state when it returns c
+	s.mu.Lock()
+	s.chunks224 = append(s.chunks224, c.h224)
+	s.lengths = append(s.lengths, n)
+	if s.weak {
+		s.weak32 = append(s.weak32, weak)
+	}
+	if c.codec != nil {
+		s.codecs = append(s.codecs, c.codec.Name())
+	} else {
+		s.codecs = append(s.codecs, "")
+	}
+	s.pending = append(s.pending, state)
+	s.mu.Unlock()
+
+	select {
+	case s.c <- c:
+		return true
+	case <-s.ctx.Done():
+	}
+
+	s.mu.Lock()
+	last := len(s.chunks224) - 1
+	s.chunks224 = s.chunks224[:last]
+	s.lengths = s.lengths[:last]
+	if s.weak {
+		s.weak32 = s.weak32[:last]
+	}
+	s.codecs = s.codecs[:last]
+	s.pending = s.pending[:len(s.pending)-1]
+	s.mu.Unlock()
+	return false
+}
+
+// doneWith marks s as finished with err, unless it already is. Only the
+// producer calls it, once it no longer touches h224.
 func (s *Sequence) doneWith(err error) {
 	s.mu.Lock()
-	s.fin = true
-	s.err = err
+	if !s.fin {
+		s.fin = true
+		s.err = err
+	}
 	s.mu.Unlock()
 }
 
@@ -95,62 +257,265 @@ func (s *Sequence) doneWith(err error) {
 // Check if the returned Sequence object is nil (invalid args) before proceeding,
 // which will be the case if w<1, bufSize<0, rc==nil, or timeout<1ms.
 func SplitStream(rc io.ReadCloser, w int64, bufSize int, timeout time.Duration) *Sequence {
-	if w < 1 || bufSize < 0 || rc == nil || timeout.Nanoseconds() < 1000*1000 {
+	sp := &Splitter{
+		Width:   w,
+		BufSize: bufSize,
+		Timeout: timeout,
+	}
+	return sp.Split(rc)
+}
+
+// SplitStreamContext is like SplitStream but runs until ctx is done instead
+// of until a timeout elapses.
+func SplitStreamContext(ctx context.Context, rc io.ReadCloser, w int64, bufSize int) *Sequence {
+	sp := &Splitter{
+		Width:   w,
+		BufSize: bufSize,
+	}
+	return sp.SplitContext(ctx, rc)
+}
+
+// Splitter holds the parameters for cutting up input streams.
+// Chunks are Width bytes long unless MaxWidth is set, in which case they are
+// cut at content-defined boundaries (see SplitStreamCDC).
+type Splitter struct {
+	Hash   Hash // algorithm for chunk and stream checksums, SHA224 by default
+	Merkle bool // whether Metadata carries a Merkle root
+
+	// MerkleTop makes the top checksum the Merkle root of the chunks instead
+	// of the checksum of the whole input, see Metadata.MerkleTop. Unlike the
+	// latter, it is computed in parallel by SplitAt.
+	MerkleTop bool
+
+	Width int64 // fixed chunk width
+
+	MinWidth int64 // content-defined chunking bounds
+	AvgWidth int64
+	MaxWidth int64
+
+	Codec  Codec   // compresses chunks if not nil, see C.Compress
+	Sealer *Sealer // seals chunks, after compression, if not nil
+
+	WeakChecksums bool // whether Metadata carries weak checksums, see ScanBasis
+
+	BufSize int           // length of the buffered chunk channel
+	Timeout time.Duration // deadline for consuming the whole stream, see Split
+
+	Workers int // chunks processed concurrently by SplitAt, runtime.NumCPU() if less than 1
+}
+
+// Split cuts up rc according to sp. It behaves like SplitStream and returns
+// nil if sp is invalid, sp.Timeout<1ms or rc==nil.
+func (sp *Splitter) Split(rc io.ReadCloser) *Sequence {
+	if sp.Timeout.Nanoseconds() < 1000*1000 {
 		return nil
 	}
+	ctx, cancel := context.WithTimeout(context.Background(), sp.Timeout)
+	return sp.split(ctx, cancel, rc)
+}
 
-	br := bufio.NewReaderSize(rc, readBufferSize) // 1 MB buffer
+// SplitContext is like Split but runs until ctx is done. sp.Timeout is
+// ignored.
+func (sp *Splitter) SplitContext(ctx context.Context, rc io.ReadCloser) *Sequence {
+	ctx, cancel := context.WithCancel(ctx)
+	return sp.split(ctx, cancel, rc)
+}
 
-	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+// Resume is like SplitContext but resumes splitting an input whose first
+// st.Metadata.Size bytes have already been split, as recorded by
+// Sequence.State, rc reading the input from there on. The chunks recorded in
+// st are not returned again by Next but are part of Metadata, which is
+// identical to that of an uninterrupted run with the same parameters.
+// It returns nil if sp is invalid, rc==nil, or st was not recorded with the
+// same hash algorithm, chunk width or bounds, top checksum and weak checksum
+// settings as sp.
+func (sp *Splitter) Resume(ctx context.Context, rc io.ReadCloser, st *SplitState) *Sequence {
+	ctx, cancel := context.WithCancel(ctx)
+	if st == nil || !st.matches(sp) {
+		cancel()
+		return nil
+	}
+	return sp.resume(ctx, cancel, rc, st)
+}
+
+func (sp *Splitter) split(ctx context.Context, cancel context.CancelFunc,
+	rc io.ReadCloser) *Sequence {
+
+	return sp.resume(ctx, cancel, rc, nil)
+}
+
+// resume starts splitting rc after the chunks recorded in st, if not nil.
+func (sp *Splitter) resume(ctx context.Context, cancel context.CancelFunc,
+	rc io.ReadCloser, st *SplitState) *Sequence {
+
+	if rc == nil || !sp.valid() {
+		cancel()
+		return nil
+	}
+
+	var next func(dst io.Writer) (int64, error)
+	if sp.MaxWidth > 0 {
+		size := readBufferSize
+		if sp.MaxWidth > int64(size) {
+			size = int(sp.MaxWidth)
+		}
+		br := bufio.NewReaderSize(rc, size)
+		cut := newCDC(sp.MinWidth, sp.AvgWidth, sp.MaxWidth)
+		next = func(dst io.Writer) (int64, error) {
+			return cut.next(br, dst)
+		}
+	} else {
+		br := bufio.NewReaderSize(rc, readBufferSize) // 1 MB buffer
+		next = func(dst io.Writer) (int64, error) {
+			return io.CopyN(dst, br, sp.Width)
+		}
+	}
+
+	s := sp.newSequence(ctx)
+	if st != nil {
+		u, ok := s.h224.(encoding.BinaryUnmarshaler)
+		if !ok || u.UnmarshalBinary(st.HashState) != nil {
+			cancel()
+			return nil
+		}
+		s.restore(st.Metadata)
+		s.state = st.HashState
+	}
+
+	go s.run(ctx, cancel, rc, next)
+
+	return s
+}
+
+// newSequence returns a Sequence of chunks cut according to sp, running
+// until ctx is done.
+func (sp *Splitter) newSequence(ctx context.Context) *Sequence {
 	s := &Sequence{
-		make(chan *C, bufSize),
-		w,
-		sha256.New224(),
+		make(chan *C, sp.BufSize),
+		make(chan struct{}),
+		ctx,
+		sp.Width,
+		[3]int64{},
+		sp.Hash,
+		sp.Merkle,
+		sp.MerkleTop,
+		sp.Codec,
+		sp.Sealer,
+		sp.WeakChecksums,
+		sp.Hash.New(),
 		sync.Mutex{},
 		false,
 		nil,
 		nil,
+		nil,
+		nil,
+		nil,
+		0,
+		nil,
+		nil,
+	}
+	if sp.MaxWidth > 0 {
+		s.w = 0
+		s.bounds = [3]int64{sp.MinWidth, sp.AvgWidth, sp.MaxWidth}
+	}
+	if bm, ok := s.h224.(encoding.BinaryMarshaler); ok {
+		s.state, _ = bm.MarshalBinary()
 	}
+	return s
+}
+
+func (sp *Splitter) valid() bool {
+	if sp.BufSize < 0 || !sp.Hash.Available() {
+		return false
+	}
+	if sp.Sealer != nil && !sp.Sealer.valid() {
+		return false
+	}
+	if sp.MaxWidth > 0 {
+		return sp.MinWidth > 0 && sp.AvgWidth >= sp.MinWidth && sp.MaxWidth >= sp.AvgWidth
+	}
+	return sp.Width > 0
+}
+
+// encode compresses then seals c, as configured for s.
+func (s *Sequence) encode(c *C) (*C, error) {
+	var err error
+	if s.codec != nil {
+		c, err = c.Compress(s.codec)
+	}
+	if err == nil && s.sealer != nil {
+		c, err = s.sealer.Seal(c)
+	}
+	return c, err
+}
+
+// run feeds s with chunks produced by next until rc is exhausted, an error is
+// encountered or ctx is done. next must write exactly one chunk into dst and
+// return io.EOF once there is no more data to be read.
+func (s *Sequence) run(ctx context.Context, cancel context.CancelFunc,
+	rc io.ReadCloser, next func(dst io.Writer) (int64, error)) {
 
+	// closing rc unblocks a pending read once ctx is done
+	var once sync.Once
+	closeRC := func() { once.Do(func() { rc.Close() }) }
+	stop := make(chan struct{})
 	go func() {
-		defer func() {
-			rc.Close()
-			close(s.c)
-			cancel()
-		}()
+		select {
+		case <-ctx.Done():
+			closeRC()
+		case <-stop:
+		}
+	}()
 
-		for {
-			select {
+	defer func() {
+		close(stop)
+		closeRC()
+		close(s.c)
+		cancel()
+		close(s.done)
+	}()
 
-			case <-ctx.Done():
-				s.doneWith(ctx.Err())
-				return
+	for {
+		select {
+
+		case <-ctx.Done():
+			s.doneWith(ctx.Err())
+			return
+
+		default:
+			chunk := bytes.NewBuffer(nil)
+			h := s.alg.New()
+			rs := &rollsum{}
+			mw := io.MultiWriter(chunk, h, rs)
+			if !s.mtop {
+				mw = io.MultiWriter(s.h224, mw)
+			}
 
-			default:
-				chunk := bytes.NewBuffer(nil)
-				h := sha256.New224()
-				mw := io.MultiWriter(s.h224, chunk, h)
-
-				n, err := io.CopyN(mw, br, w)
-				if err != nil {
-					if err == io.EOF { // last chunk
-						if n > 0 {
-							s.c <- &C{chunk.Bytes(), h}
-							s.chunks224 = append(s.chunks224, h)
-						}
-						s.doneWith(nil)
-					} else {
-						s.doneWith(err)
-					}
+			n, err := next(mw)
+			if err != nil && err != io.EOF {
+				if ctx.Err() != nil {
+					err = ctx.Err() // rc closed by cancellation
+				}
+				s.doneWith(err)
+				return
+			}
 
+			if n > 0 { // the last chunk may be empty
+				c, cerr := s.encode(&C{chunk.Bytes(), h, s.alg, nil, false})
+				if cerr != nil {
+					s.doneWith(cerr)
 					return
 				}
+				if !s.push(c, n, rs.Sum32()) {
+					s.doneWith(ctx.Err())
+					return
+				}
+			}
 
-				s.c <- &C{chunk.Bytes(), h}
-				s.chunks224 = append(s.chunks224, h)
+			if err == io.EOF {
+				s.doneWith(nil)
+				return
 			}
 		}
-	}()
-
-	return s
+	}
 }
@@ -2,8 +2,10 @@ package chunk
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"io"
+	"io/ioutil"
 	"os"
 	"os/exec"
 	"strings"
@@ -111,6 +113,29 @@ func TestEdgeCases(t *testing.T) {
 	assert.Equal(t, sha224bin("testdata/all"), sum224.String())
 }
 
+func TestMetadataOffsets(t *testing.T) {
+	f, err := os.Open("testdata/all")
+	assert.Nil(t, err)
+	defer f.Close()
+
+	s := SplitStream(f, 30, 5, 1*time.Second)
+	for c := s.Next(); c != nil; c = s.Next() {
+	}
+
+	m, err := s.Metadata()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(129), m.Size)
+	assert.Equal(t, []int64{0, 30, 60, 90, 120}, m.ChunkOffsets)
+	assert.Equal(t, []int64{30, 30, 30, 30, 9}, m.ChunkLengths)
+
+	assert.Equal(t, 0, m.Index(0))
+	assert.Equal(t, 0, m.Index(29))
+	assert.Equal(t, 1, m.Index(30))
+	assert.Equal(t, 4, m.Index(128))
+	assert.Equal(t, -1, m.Index(129))
+	assert.Equal(t, -1, m.Index(-1))
+}
+
 func TestTimeout(t *testing.T) {
 	pr, pw := dummyPipe()
 	defer pr.Close()
@@ -126,11 +151,86 @@ func TestTimeout(t *testing.T) {
 		}
 	}
 
+	<-s.done
 	fin, err := s.Err()
 	assert.True(t, fin)
 	assert.Equal(t, "context deadline exceeded", err.Error())
 }
 
+func TestSplitStreamContext(t *testing.T) {
+	// reader blocked forever
+	pr, pw := io.Pipe()
+	defer pw.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s := SplitStreamContext(ctx, pr, 10, 0)
+	assert.NotNil(t, s)
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		cancel()
+	}()
+
+	start := time.Now()
+	assert.Nil(t, s.Next())
+	assert.True(t, time.Since(start) < 1*time.Second)
+
+	<-s.done
+	fin, err := s.Err()
+	assert.True(t, fin)
+	assert.Equal(t, context.Canceled, err)
+
+	// reader still producing when cancelled
+	busyR, busyW := io.Pipe()
+	go func() {
+		for {
+			if _, err := busyW.Write(make([]byte, 7)); err != nil {
+				return
+			}
+		}
+	}()
+	ctx, cancel = context.WithCancel(context.Background())
+	s = SplitStreamContext(ctx, busyR, 10, 0)
+	assert.NotNil(t, s.Next())
+	cancel()
+	for c := s.Next(); c != nil; c = s.Next() {
+	}
+	<-s.done
+	_, err = s.Metadata()
+	assert.Equal(t, context.Canceled, err)
+	_, err = s.Sum224()
+	assert.Equal(t, context.Canceled, err)
+
+	// reader whose Close does not unblock it
+	blockedR, blockedW := io.Pipe()
+	defer blockedW.Close()
+	ctx, cancel = context.WithCancel(context.Background())
+	s = SplitStreamContext(ctx, ioutil.NopCloser(blockedR), 10, 0)
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		cancel()
+	}()
+	start = time.Now()
+	assert.Nil(t, s.Next())
+	assert.True(t, time.Since(start) < 1*time.Second)
+	_, err = s.Metadata()
+	assert.Equal(t, ErrStreamStillRunning, err)
+	_, err = s.Sum224()
+	assert.Equal(t, ErrStreamStillRunning, err)
+
+	// producer does not leak when nobody reads
+	pr, pw = dummyPipe()
+	defer pr.Close()
+	defer pw.Close()
+	ctx, cancel = context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	s = SplitStreamContext(ctx, pr, 1, 0)
+	time.Sleep(200 * time.Millisecond)
+	fin, err = s.Err()
+	assert.True(t, fin)
+	assert.Equal(t, context.DeadlineExceeded, err)
+}
+
 func TestStreamError(t *testing.T) {
 	pr, pw := dummyPipe()
 	defer pr.Close()
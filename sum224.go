@@ -4,10 +4,10 @@ import (
 	"bytes"
 	"crypto/sha256"
 	"encoding/hex"
-	"errors"
 )
 
-// Sum224 is SHA-224 as byte array.
+// Sum224 is a 224-bit checksum as byte array. It holds SHA-224 unless another
+// Hash is chosen.
 type Sum224 [sha256.Size224]byte
 
 // String returns the hex string representation of s.
@@ -34,9 +34,24 @@ func NewSum224(s string) (Sum224, error) {
 		return Sum224{}, err
 	}
 	if n != 28 {
-		return Sum224{}, errors.New("hex string not 224-bit")
+		return Sum224{}, ErrSum224Length
 	}
 	var res Sum224
 	copy(res[:], dst)
 	return res, nil
 }
+
+// MarshalText implements encoding.TextMarshaler, encoding s as hex.
+func (s Sum224) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler, decoding hex into s.
+func (s *Sum224) UnmarshalText(b []byte) error {
+	res, err := NewSum224(string(b))
+	if err != nil {
+		return err
+	}
+	*s = res
+	return nil
+}
@@ -0,0 +1,220 @@
+package chunk
+
+import (
+	"context"
+	"io"
+)
+
+// atState is the state of a Reconstructor writing chunks at their offset of
+// an io.WriterAt.
+type atState struct {
+	// read only
+	w        io.WriterAt
+	offsets  []int64
+	size     int64
+	readable bool // whether the output is read back to check the top checksum
+
+	// r/w, under the Reconstructor's lock
+	written []uint64 // bitmap of the chunks written
+	left    int      // chunks not written yet
+
+	// receives nil once every chunk has been written, or the error which
+	// stopped the writing
+	stop chan error
+}
+
+// set marks chunk i as written.
+func (at *atState) set(i int) {
+	if at.written[i/64]&(1<<uint(i%64)) == 0 {
+		at.written[i/64] |= 1 << uint(i%64)
+		at.left--
+	}
+}
+
+func (at *atState) isSet(i int) bool {
+	return at.written[i/64]&(1<<uint(i%64)) != 0
+}
+
+// halt hands err over to the goroutine waiting on at.stop, unless it already
+// has something to report.
+func (at *atState) halt(err error) {
+	select {
+	case at.stop <- err:
+	default:
+	}
+}
+
+// ReconstructAt returns a Reconstructor writing every chunk submitted to it
+// straight at its offset of wa, instead of holding it until the chunks before
+// it have been written. Which chunks have been written is tracked with a
+// bitmap, see Missing.
+// Every chunk is verified against its expected checksum right before being
+// written, and Submit returns the *ChunkError of a corrupt chunk or of a
+// failed write on top of stopping the reconstruction. Chunks submitted
+// concurrently are verified and written concurrently, wa must allow it as
+// io.WriterAt requires.
+// Once every chunk has been written, if wa is also an io.ReaderAt, such as an
+// *os.File, the whole output is read back sequentially and its checksum
+// compared with m.TopChecksum, as with ReconstructMetadata. Otherwise only
+// the chunk checksums of m have been checked, and Sum224 fails with
+// ErrTopChecksumUnchecked rather than report a checksum nothing was checked
+// against. A Merkle top checksum (see Metadata.MerkleTop) is never read back
+// for, since it covers the chunk checksums.
+//
+// Bytes of wa past m.Size are left untouched.
+// Erasure coding is supported like in ReconstructMetadata.
+// The returned Reconstructor runs until every chunk has been written and
+// checked or ctx is done. It is nil if m has no chunks or is inconsistent.
+func ReconstructAt(ctx context.Context, wa io.WriterAt, m *Metadata) *Reconstructor {
+	if m.validate() != nil {
+		return nil
+	}
+	return reconstructAt(ctx, wa, m, nil)
+}
+
+// reconstructAt is ReconstructAt for a valid m, the chunks in written having
+// already been written if it is not nil.
+func reconstructAt(ctx context.Context, wa io.WriterAt, m *Metadata, written []bool) *Reconstructor {
+	if wa == nil {
+		return nil
+	}
+	ra, readable := wa.(io.ReaderAt)
+	rec := newReconstructor(m)
+	if rec == nil {
+		return nil
+	}
+	n := len(m.ChunkChecksums)
+	rec.top = &m.TopChecksum
+	rec.at = &atState{
+		wa,
+		m.ChunkOffsets,
+		m.Size,
+		readable,
+		make([]uint64, (n+63)/64),
+		n,
+		make(chan error, 1),
+	}
+	for i, v := range written {
+		if v {
+			rec.at.set(i)
+		}
+	}
+	rec.markWritten()
+	if rec.at.left == 0 {
+		rec.at.halt(nil)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	go func() {
+		defer func() {
+			cancel()
+			rec.mu.Lock()
+			rec.closeCheckpoint(wa, 0, 0)
+			rec.removeSpill()
+			rec.mu.Unlock()
+			close(rec.done)
+		}()
+
+		select {
+		case <-ctx.Done():
+			rec.doneWith(ctx.Err())
+		case err := <-rec.at.stop:
+			if err == nil && readable && !m.MerkleTop {
+				err = rec.readBack(ctx, ra, m)
+			}
+			rec.doneWith(err)
+		}
+	}()
+
+	return rec
+}
+
+// assume external lock
+// writeAt writes every queued chunk at its offset. Chunks are taken from the
+// sorter under the lock, which is released while they are checked and written
+// so that concurrent Submits write concurrently, and taken again to mark them
+// written.
+func (rec *Reconstructor) writeAt() error {
+	for len(rec.sorter) > 0 {
+		c, want, err := rec.takeChunk(rec.chunkHashes)
+		if err == nil {
+			rec.mu.Unlock()
+			err = checkChunk(c, want)
+			if err == nil {
+				if _, werr := rec.at.w.WriteAt(c.b, rec.at.offsets[c.idx]); werr != nil {
+					err = &ChunkError{Index: c.idx, Sum: want, Err: werr}
+				}
+			}
+			rec.mu.Lock()
+		}
+		if err != nil {
+			// stopped right away, the goroutine only records it
+			if !rec.fin {
+				rec.fin = true
+				rec.err = err
+			}
+			rec.at.halt(err)
+			return err
+		}
+		rec.at.set(c.idx)
+		if rec.fin {
+			// stopped by another Submit or ctx while c was written
+			return nil
+		}
+	}
+	if rec.at.left == 0 {
+		rec.at.halt(nil)
+		return nil
+	}
+	if rec.ckpt != nil && rec.ckpt.due() {
+		if err := rec.saveCheckpoint(rec.at.w, 0, 0); err != nil {
+			rec.fin = true
+			rec.err = err
+			rec.at.halt(err)
+			return err
+		}
+	}
+	return nil
+}
+
+// readBack checks the checksum of the m.Size first bytes of ra against
+// m.TopChecksum.
+func (rec *Reconstructor) readBack(ctx context.Context, ra io.ReaderAt, m *Metadata) error {
+	r := io.NewSectionReader(ra, 0, m.Size)
+	buf := make([]byte, readBufferSize)
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		n, err := r.Read(buf)
+		rec.h224.Write(buf[:n])
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if !m.TopChecksum.EqB(rec.h224.Sum(nil)) {
+		return ErrTopChecksum
+	}
+	return nil
+}
+
+// Missing returns the indexes of the chunks not written yet by a
+// Reconstructor created with ReconstructAt, in increasing order. It returns
+// nil for other Reconstructors.
+func (rec *Reconstructor) Missing() []int {
+	if rec.at == nil {
+		return nil
+	}
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	res := []int{}
+	for i := range rec.chunkHashes {
+		if !rec.at.isSet(i) {
+			res = append(res, i)
+		}
+	}
+	return res
+}
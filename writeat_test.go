@@ -0,0 +1,177 @@
+package chunk
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// memWriterAt is an io.WriterAt which is not an io.ReaderAt.
+type memWriterAt struct {
+	b  []byte
+	mu sync.Mutex
+}
+
+func (m *memWriterAt) WriteAt(p []byte, off int64) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if n := int(off) + len(p); n > len(m.b) {
+		m.b = append(m.b, make([]byte, n-len(m.b))...)
+	}
+	return copy(m.b[off:], p), nil
+}
+
+func TestReconstructAt(t *testing.T) {
+	data := make([]byte, 10*1000+123)
+	rand.New(rand.NewSource(6)).Read(data)
+	m, chunks := metadataOf(t, data, 700)
+
+	f, err := ioutil.TempFile("", "writeat")
+	assert.Nil(t, err)
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	rec := ReconstructAt(context.Background(), f, m)
+	assert.Equal(t, len(chunks), len(rec.Missing()))
+
+	// last chunk first, then the others in random order
+	assert.Nil(t, rec.Submit(chunks[len(chunks)-1]))
+	assert.Equal(t, len(chunks)-1, len(rec.Missing()))
+	assert.Equal(t, len(chunks)-2, rec.Missing()[len(chunks)-2])
+	for _, i := range rand.New(rand.NewSource(7)).Perm(len(chunks) - 1) {
+		assert.Nil(t, rec.Submit(chunks[i]))
+	}
+	<-rec.Done()
+	fin, err := rec.Err()
+	assert.True(t, fin)
+	assert.Nil(t, err)
+	assert.Equal(t, []int{}, rec.Missing())
+	sum, err := rec.Sum224()
+	assert.Nil(t, err)
+	assert.Equal(t, m.TopChecksum, sum)
+
+	b, err := ioutil.ReadFile(f.Name())
+	assert.Nil(t, err)
+	assert.Equal(t, data, b)
+
+	// the output read back does not match
+	m.TopChecksum[0]++
+	g, err := ioutil.TempFile("", "writeat")
+	assert.Nil(t, err)
+	defer os.Remove(g.Name())
+	defer g.Close()
+	rec = ReconstructAt(context.Background(), g, m)
+	for _, c := range chunks {
+		assert.Nil(t, rec.Submit(c))
+	}
+	<-rec.Done()
+	_, err = rec.Err()
+	assert.Equal(t, ErrTopChecksum, err)
+
+	// regular Reconstructors have no bitmap
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	assert.Nil(t, ReconstructMetadataContext(ctx, nopWriteCloser{ioutil.Discard}, m).Missing())
+}
+
+func TestReconstructAtUnreadable(t *testing.T) {
+	data := make([]byte, 5000)
+	rand.New(rand.NewSource(8)).Read(data)
+	m, chunks := metadataOf(t, data, 512)
+
+	// the top checksum cannot be checked, whatever it is
+	m.TopChecksum[0]++
+	w := &memWriterAt{}
+	rec := ReconstructAt(context.Background(), w, m)
+	for i := len(chunks) - 1; i >= 0; i-- {
+		assert.Nil(t, rec.Submit(chunks[i]))
+	}
+	<-rec.Done()
+	_, err := rec.Err()
+	assert.Nil(t, err)
+	_, err = rec.Sum224()
+	assert.Equal(t, ErrTopChecksumUnchecked, err)
+	assert.Equal(t, data, w.b)
+}
+
+// gateWriterAt holds its first write back until another write has started,
+// or for a second at most.
+type gateWriterAt struct {
+	memWriterAt
+	first, second sync.Once
+	started       chan struct{}
+	overlapped    bool // whether the first write saw another one start
+}
+
+func (g *gateWriterAt) WriteAt(p []byte, off int64) (int, error) {
+	first := false
+	g.first.Do(func() { first = true })
+	if !first {
+		g.second.Do(func() { close(g.started) })
+		return g.memWriterAt.WriteAt(p, off)
+	}
+	select {
+	case <-g.started:
+		g.mu.Lock()
+		g.overlapped = true
+		g.mu.Unlock()
+	case <-time.After(time.Second):
+	}
+	return g.memWriterAt.WriteAt(p, off)
+}
+
+func TestReconstructAtConcurrent(t *testing.T) {
+	data := make([]byte, 2000)
+	rand.New(rand.NewSource(9)).Read(data)
+	m, chunks := metadataOf(t, data, 1000)
+
+	w := &gateWriterAt{started: make(chan struct{})}
+	rec := ReconstructAt(context.Background(), w, m)
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		assert.Nil(t, rec.Submit(chunks[0]))
+	}()
+	time.Sleep(50 * time.Millisecond) // chunk 0 being written
+	assert.Nil(t, rec.Submit(chunks[1]))
+	wg.Wait()
+	<-rec.Done()
+
+	_, err := rec.Err()
+	assert.Nil(t, err)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	assert.True(t, w.overlapped)
+	assert.Equal(t, data, w.b)
+}
+
+func TestReconstructAtCorrupt(t *testing.T) {
+	m, chunks := metadataOf(t, []byte("aaaabbbbcccc"), 4)
+	w := &memWriterAt{}
+	rec := ReconstructAt(context.Background(), w, m)
+	assert.Nil(t, rec.Submit(chunks[2]))
+
+	// a chunk claiming to be chunk 0
+	bad := *chunks[0]
+	bad.b = []byte("xxxx")
+	err := rec.Submit(&bad)
+	assert.True(t, errors.Is(err, ErrChunkChecksum))
+	assert.Equal(t, 0, err.(*ChunkError).Index)
+	<-rec.Done()
+	_, err = rec.Err()
+	assert.True(t, errors.Is(err, ErrChunkChecksum))
+	assert.Equal(t, []int{0, 1}, rec.Missing())
+
+	err = rec.Submit(chunks[1])
+	assert.True(t, errors.Is(err, ErrFinishedReconstructor))
+	assert.Equal(t, []byte("\x00\x00\x00\x00\x00\x00\x00\x00cccc"), w.b)
+	assert.Nil(t, ReconstructAt(context.Background(), w, &Metadata{}))
+}